@@ -0,0 +1,189 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Admin manages queue messages, such as in admin tools or dashboards.
+type Admin interface {
+	// get message statistics per consumer and per event, i.e, due, scheduled, in retry and dead count,
+	// the oldest due message's age and average retry
+	Stats(ctx context.Context) (*Stats, error)
+
+	// get current queue health, i.e, Stats, the oldest due message and retry distribution of live messages
+	Overview(ctx context.Context) (*Overview, error)
+
+	// get one live or dead message by queue id
+	Message(ctx context.Context, id int64) (*Queue, error)
+
+	// list live messages matching the filter, oldest first
+	Messages(ctx context.Context, filter MessageFilter) ([]Queue, error)
+
+	// get the greatest queue id of live messages, or zero without live messages, such as to only list
+	// messages sent from now on with MessageFilter.AfterID
+	LastMessageID(ctx context.Context) (int64, error)
+
+	// insert one message for given consumers, to be consumed at given time, no matter whether these consumers
+	// are registered in current process. It returns generated queue ids keyed by consumer name.
+	Enqueue(ctx context.Context, message Message, consumerNames []string, at time.Time) (MessageIDs, error)
+
+	// re-enqueue archived events for one consumer, such as one new consumer which only sees future messages.
+	// It returns how many messages are enqueued, or would be enqueued with dry run.
+	Replay(ctx context.Context, req ReplayRequest) (int64, error)
+
+	// re-enqueue externally exported messages for one consumer, at most rate messages per second.
+	// Zero rate means unlimited.
+	ReplayMessages(ctx context.Context, consumerName string, messages []Message, rate int) (int64, error)
+
+	// write live or dead messages matching the filter to w as JSON lines, oldest first, including metadata
+	// such as consumer name, retry and failed reason. It returns how many messages are exported.
+	Export(ctx context.Context, w io.Writer, filter ExportFilter) (int64, error)
+
+	// insert messages exported by Export from r, such as into one dev database, within one tx.
+	// Imported messages get new queue ids, and are consumed right now. It returns how many messages are imported.
+	Import(ctx context.Context, r io.Reader, opts ImportOptions) (int64, error)
+
+	// rewrite pending messages of one consumer to its new name, such as after one consumer is renamed and all
+	// processes run the new version. It returns how many messages are renamed. See AliasedConsumer.
+	RenameConsumer(ctx context.Context, from, to string) (int64, error)
+
+	// get attempt history of one message by queue id, ordered by attempt number
+	Attempts(ctx context.Context, queueID int64) ([]Attempt, error)
+
+	// list failed attempts of all messages after the attempt id, oldest first
+	FailedAttempts(ctx context.Context, afterID int64, limit int) ([]Attempt, error)
+
+	// get the greatest attempt id, or zero without attempts, such as to only list attempts failed from now on
+	LastAttemptID(ctx context.Context) (int64, error)
+
+	// list dead messages matching the filter, newest first
+	DeadMessages(ctx context.Context, filter DeadFilter) ([]Queue, error)
+
+	// requeue dead messages by queue ids, resetting retry, and to be consumed right now.
+	// It returns how many messages are requeued.
+	RequeueDead(ctx context.Context, ids ...int64) (int64, error)
+
+	// requeue dead messages matching the filter. Empty filter matches, and requeues, every dead message.
+	RequeueDeadByFilter(ctx context.Context, filter DeadFilter) (int64, error)
+
+	// requeue one dead message with one edited payload
+	RequeueDeadWithMessage(ctx context.Context, id int64, message MQMessage) (int64, error)
+
+	// delete dead messages by queue ids. It returns how many messages are deleted.
+	PurgeDead(ctx context.Context, ids ...int64) (int64, error)
+
+	// delete dead messages matching the filter. Empty filter matches, and deletes, every dead message.
+	PurgeDeadByFilter(ctx context.Context, filter DeadFilter) (int64, error)
+}
+
+// AdminOption configures the admin created by NewAdmin.
+type AdminOption interface {
+	applyAdmin(a *admin)
+}
+
+func NewAdmin(pool *pgxpool.Pool, opts ...AdminOption) Admin {
+	a := admin{
+		pool:     pool,
+		registry: defaultRegistry,
+	}
+	for _, opt := range opts {
+		opt.applyAdmin(&a)
+	}
+	return a
+}
+
+type admin struct {
+	pool *pgxpool.Pool
+	// registered consumers, used to find one consumer's event
+	registry Registry
+}
+
+// MessageFilter filters live messages. Zero value fields are ignored.
+type MessageFilter struct {
+	// only messages of this consumer
+	ConsumerName string
+	// only messages of this event
+	Event Event
+	// only messages whose queue id is greater than this id
+	AfterID int64
+	// max number of messages to list, default 100
+	Limit int
+}
+
+func (a admin) Messages(ctx context.Context, filter MessageFilter) ([]Queue, error) {
+	var (
+		conds = []string{"is_dead = false"}
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.ConsumerName != "" {
+		add("consumer_name = $%d", filter.ConsumerName)
+	}
+	if filter.Event != "" {
+		add("message->>'event' = $%d", filter.Event.String())
+	}
+	if filter.AfterID > 0 {
+		add("id > $%d", filter.AfterID)
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit)
+	query := fmt.Sprintf(`select * from queues where %s order by id limit $%d`, strings.Join(conds, " and "), len(args))
+
+	queues := []Queue{}
+	if err := pgxscan.Select(ctx, a.pool, &queues, query, args...); err != nil {
+		return nil, fmt.Errorf("error selecting messages: %w", err)
+	}
+	return queues, nil
+}
+
+func (a admin) LastMessageID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := a.pool.QueryRow(ctx, `select coalesce(max(id), 0) from queues where is_dead = false`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error selecting last message id: %w", err)
+	}
+	return id, nil
+}
+
+func (a admin) Enqueue(ctx context.Context, message Message, consumerNames []string, at time.Time) (MessageIDs, error) {
+	rows := make([]queueRow, 0, len(consumerNames))
+	for _, name := range consumerNames {
+		rows = append(rows, queueRow{
+			consumerName: name,
+			message:      message,
+			checkAt:      at.UTC(),
+		})
+	}
+
+	tx, err := a.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting tx at enqueue: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := insertRows(ctx, tx, rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing tx at enqueue: %w", err)
+	}
+
+	result := make(MessageIDs, len(rows))
+	for i, row := range rows {
+		result[row.consumerName] = ids[i]
+	}
+	return result, nil
+}
@@ -0,0 +1,211 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+// AlertKind is which threshold one alert is about.
+type AlertKind string
+
+const (
+	// the oldest due message has waited longer than the threshold. Value and threshold are in seconds.
+	AlertOldestDueAge AlertKind = "oldest_due_age"
+	// live messages, i.e, due and scheduled, are more than the threshold
+	AlertBacklog AlertKind = "backlog"
+	// dead messages have grown more than the threshold since last evaluation
+	AlertDeadGrowth AlertKind = "dead_growth"
+)
+
+// AlertRule holds thresholds of one consumer. Zero thresholds are not checked.
+type AlertRule struct {
+	// empty consumer name applies this rule to every consumer without its own rule
+	ConsumerName    string
+	MaxOldestDueAge time.Duration
+	MaxBacklog      int64
+	MaxDeadGrowth   int64
+}
+
+// Alert is fired once when one threshold is breached, and once again when it has recovered.
+type Alert struct {
+	ConsumerName string    `json:"consumer_name"`
+	Kind         AlertKind `json:"kind"`
+	// true when breached, false when recovered
+	Firing    bool      `json:"firing"`
+	Value     int64     `json:"value"`
+	Threshold int64     `json:"threshold"`
+	At        time.Time `json:"at"`
+}
+
+func (a Alert) String() string {
+	state := "recovered"
+	if a.Firing {
+		state = "firing"
+	}
+	return fmt.Sprintf("mq alert %s: consumer %s %s is %d, threshold %d", state, a.ConsumerName, a.Kind, a.Value, a.Threshold)
+}
+
+// AlertHook receives alerts.
+type AlertHook interface {
+	Alert(ctx context.Context, alert Alert) error
+}
+
+// AlertHookFunc adapts one function to AlertHook.
+type AlertHookFunc func(ctx context.Context, alert Alert) error
+
+func (f AlertHookFunc) Alert(ctx context.Context, alert Alert) error {
+	return f(ctx, alert)
+}
+
+// NewWebhookAlertHook returns one AlertHook posting each alert as json to the url.
+func NewWebhookAlertHook(url string) AlertHook {
+	return webhookAlertHook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type webhookAlertHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h webhookAlertHook) Alert(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("error encoding alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating alert request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending alert: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("error sending alert: webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// alerter evaluates alert rules against queue stats, and remembers which alerts are firing.
+// It's only used by one goroutine.
+type alerter struct {
+	interval time.Duration
+	hook     AlertHook
+	rules    map[string]AlertRule
+	firing   map[string]bool
+	// dead count per consumer at last evaluation
+	lastDead map[string]int64
+}
+
+func newAlerter(interval time.Duration, hook AlertHook, rules []AlertRule) *alerter {
+	a := &alerter{
+		interval: interval,
+		hook:     hook,
+		rules:    make(map[string]AlertRule, len(rules)),
+		firing:   make(map[string]bool),
+		lastDead: make(map[string]int64),
+	}
+	for _, rule := range rules {
+		a.rules[rule.ConsumerName] = rule
+	}
+	return a
+}
+
+// run evaluates rules periodically.
+func (a *alerter) run(admin Admin) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+		stats, err := admin.Stats(ctx)
+		if err != nil {
+			log.Errorf("MQ: error getting stats for alerts: (%v)", err)
+		} else {
+			a.evaluate(ctx, stats, time.Now().UTC())
+		}
+		cancel()
+	}
+}
+
+func (a *alerter) evaluate(ctx context.Context, stats *Stats, now time.Time) {
+	// consumers without any message still need checking, so their firing alerts recover
+	names := make(map[string]bool, len(stats.Consumers)+len(a.rules))
+	for name := range stats.Consumers {
+		names[name] = true
+	}
+	for name := range a.rules {
+		if name != "" {
+			names[name] = true
+		}
+	}
+	for name := range a.lastDead {
+		names[name] = true
+	}
+
+	for name := range names {
+		rule, ok := a.rules[name]
+		if !ok {
+			if rule, ok = a.rules[""]; !ok {
+				continue
+			}
+		}
+		s := stats.Consumers[name]
+
+		if rule.MaxOldestDueAge > 0 {
+			a.check(ctx, name, AlertOldestDueAge, int64(s.OldestDueAge/time.Second), int64(rule.MaxOldestDueAge/time.Second),
+				s.OldestDueAge > rule.MaxOldestDueAge, now)
+		}
+		if rule.MaxBacklog > 0 {
+			backlog := s.Due + s.Scheduled
+			a.check(ctx, name, AlertBacklog, backlog, rule.MaxBacklog, backlog > rule.MaxBacklog, now)
+		}
+		if rule.MaxDeadGrowth > 0 {
+			last, seen := a.lastDead[name]
+			growth := s.Dead - last
+			// the first evaluation only records the baseline
+			if seen {
+				a.check(ctx, name, AlertDeadGrowth, growth, rule.MaxDeadGrowth, growth > rule.MaxDeadGrowth, now)
+			}
+		}
+		a.lastDead[name] = s.Dead
+	}
+}
+
+// check fires one alert when the breached state changes.
+func (a *alerter) check(ctx context.Context, name string, kind AlertKind, value, threshold int64, breached bool, now time.Time) {
+	key := name + "\x00" + string(kind)
+	if a.firing[key] == breached {
+		return
+	}
+	alert := Alert{
+		ConsumerName: name,
+		Kind:         kind,
+		Firing:       breached,
+		Value:        value,
+		Threshold:    threshold,
+		At:           now,
+	}
+	if err := a.hook.Alert(ctx, alert); err != nil {
+		// keep current state, so this alert is fired again at next evaluation
+		log.Errorf("MQ: error firing alert: %s: (%v)", alert, err)
+		return
+	}
+	if breached {
+		a.firing[key] = true
+	} else {
+		delete(a.firing, key)
+	}
+}
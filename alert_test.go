@@ -0,0 +1,152 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// recordHook records fired alerts, and fails while err is set.
+type recordHook struct {
+	alerts []Alert
+	err    error
+}
+
+func (h *recordHook) Alert(ctx context.Context, alert Alert) error {
+	if h.err != nil {
+		return h.err
+	}
+	h.alerts = append(h.alerts, alert)
+	return nil
+}
+
+// fired returns alerts fired since last call, sorted by consumer name and kind.
+func (h *recordHook) fired() []Alert {
+	alerts := h.alerts
+	h.alerts = nil
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].ConsumerName != alerts[j].ConsumerName {
+			return alerts[i].ConsumerName < alerts[j].ConsumerName
+		}
+		return alerts[i].Kind < alerts[j].Kind
+	})
+	return alerts
+}
+
+func TestAlerterEvaluate(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	stats := func(consumers map[string]QueueStats) *Stats {
+		return &Stats{Consumers: consumers}
+	}
+
+	tests := []struct {
+		name  string
+		rules []AlertRule
+		// evaluated one by one, with alerts expected after each evaluation
+		steps []*Stats
+		want  [][]Alert
+	}{
+		{
+			name:  "backlog fires once, then recovers",
+			rules: []AlertRule{{ConsumerName: "a", MaxBacklog: 10}},
+			steps: []*Stats{
+				stats(map[string]QueueStats{"a": {Due: 6, Scheduled: 5}}),
+				stats(map[string]QueueStats{"a": {Due: 20}}),
+				stats(map[string]QueueStats{"a": {Due: 10}}),
+			},
+			want: [][]Alert{
+				{{ConsumerName: "a", Kind: AlertBacklog, Firing: true, Value: 11, Threshold: 10, At: now}},
+				nil,
+				{{ConsumerName: "a", Kind: AlertBacklog, Firing: false, Value: 10, Threshold: 10, At: now}},
+			},
+		},
+		{
+			name:  "consumer without messages recovers",
+			rules: []AlertRule{{ConsumerName: "a", MaxOldestDueAge: time.Minute}},
+			steps: []*Stats{
+				stats(map[string]QueueStats{"a": {Due: 1, OldestDueAge: 90 * time.Second}}),
+				stats(map[string]QueueStats{}),
+			},
+			want: [][]Alert{
+				{{ConsumerName: "a", Kind: AlertOldestDueAge, Firing: true, Value: 90, Threshold: 60, At: now}},
+				{{ConsumerName: "a", Kind: AlertOldestDueAge, Firing: false, Value: 0, Threshold: 60, At: now}},
+			},
+		},
+		{
+			name: "default rule applies to consumers without their own rule",
+			rules: []AlertRule{
+				{MaxBacklog: 5},
+				{ConsumerName: "b", MaxBacklog: 100},
+			},
+			steps: []*Stats{
+				stats(map[string]QueueStats{"a": {Due: 6}, "b": {Due: 50}, "c": {Scheduled: 9}}),
+			},
+			want: [][]Alert{
+				{
+					{ConsumerName: "a", Kind: AlertBacklog, Firing: true, Value: 6, Threshold: 5, At: now},
+					{ConsumerName: "c", Kind: AlertBacklog, Firing: true, Value: 9, Threshold: 5, At: now},
+				},
+			},
+		},
+		{
+			name:  "dead growth is measured from the first evaluation",
+			rules: []AlertRule{{ConsumerName: "a", MaxDeadGrowth: 2}},
+			steps: []*Stats{
+				stats(map[string]QueueStats{"a": {Dead: 100}}),
+				stats(map[string]QueueStats{"a": {Dead: 102}}),
+				stats(map[string]QueueStats{"a": {Dead: 105}}),
+				stats(map[string]QueueStats{"a": {Dead: 105}}),
+			},
+			want: [][]Alert{
+				nil,
+				nil,
+				{{ConsumerName: "a", Kind: AlertDeadGrowth, Firing: true, Value: 3, Threshold: 2, At: now}},
+				{{ConsumerName: "a", Kind: AlertDeadGrowth, Firing: false, Value: 0, Threshold: 2, At: now}},
+			},
+		},
+		{
+			name:  "consumers without any rule are not checked",
+			rules: []AlertRule{{ConsumerName: "a", MaxBacklog: 1}},
+			steps: []*Stats{
+				stats(map[string]QueueStats{"b": {Due: 1000}}),
+			},
+			want: [][]Alert{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordHook{}
+			a := newAlerter(time.Minute, hook, tt.rules)
+			for i, s := range tt.steps {
+				a.evaluate(context.Background(), s, now)
+				if got := hook.fired(); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("evaluation %d fired %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAlerterEvaluateHookError(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	hook := &recordHook{err: errors.New("webhook down")}
+	a := newAlerter(time.Minute, hook, []AlertRule{{ConsumerName: "a", MaxBacklog: 1}})
+	stats := &Stats{Consumers: map[string]QueueStats{"a": {Due: 2}}}
+
+	a.evaluate(context.Background(), stats, now)
+	if got := hook.fired(); len(got) != 0 {
+		t.Fatalf("fired %v while hook fails", got)
+	}
+
+	// the alert is fired again once the hook works
+	hook.err = nil
+	a.evaluate(context.Background(), stats, now)
+	want := []Alert{{ConsumerName: "a", Kind: AlertBacklog, Firing: true, Value: 2, Threshold: 1, At: now}}
+	if got := hook.fired(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fired %v, want %v", got, want)
+	}
+}
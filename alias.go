@@ -0,0 +1,38 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (a admin) RenameConsumer(ctx context.Context, from, to string) (int64, error) {
+	if from == "" || to == "" {
+		return 0, errors.New("rename consumer names are required")
+	}
+	if from == to {
+		return 0, nil
+	}
+
+	// One pending message of the new name may have taken the same coalescing key already, then the renamed message
+	// loses its coalescing key, instead of violating the unique index.
+	query := `with renamed as (
+			update queues set consumer_name = $2,
+				coalesce_key = case when exists (
+					select 1 from queues taken where taken.consumer_name = $2
+					and taken.coalesce_key = queues.coalesce_key and taken.is_dead = false
+				) then null else coalesce_key end
+			where consumer_name = $1 and is_dead = false
+			returning id
+		), jobs as (
+			update queue_jobs set consumer_name = $2, updated_at = $3 where id in (select id from renamed)
+		)
+		select count(*) from renamed`
+
+	var count int64
+	if err := a.pool.QueryRow(ctx, query, from, to, time.Now().UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error renaming consumer: %w", err)
+	}
+	return count, nil
+}
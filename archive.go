@@ -0,0 +1,33 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// archive moves one consumed message from table `queues` into table `queue_archives`, together with
+// its completion time, attempt count and processing duration.
+func (c consume) archiveMessage(ctx context.Context, tx pgx.Tx, queue Queue, startedAt time.Time) error {
+	now := time.Now().UTC()
+	query := `with moved as (
+			delete from queues where id = $1 returning id, consumer_name, message, retry, created_at
+		)
+		insert into queue_archives (id, consumer_name, event, message, attempts, created_at, completed_at, duration_ms)
+		select id, consumer_name, message->>'event', message, retry + 1, created_at, $2, $3 from moved`
+	if _, err := tx.Exec(ctx, query, queue.ID, now, now.Sub(startedAt).Milliseconds()); err != nil {
+		return fmt.Errorf("error archiving message: %w", err)
+	}
+	return nil
+}
+
+// pruneArchives deletes archived messages older than archive retention.
+func (c consume) pruneArchives(ctx context.Context) error {
+	query := `delete from queue_archives where completed_at < $1`
+	if _, err := c.pool.Exec(ctx, query, time.Now().UTC().Add(-c.archiveRetention)); err != nil {
+		return fmt.Errorf("error pruning archives: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// Attempt is one consume attempt of one message, saved at table `queue_attempts`.
+type Attempt struct {
+	ID      int64 `json:"id"`
+	QueueID int64 `json:"queue_id"`
+	// starts from 1
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// which worker consumed this message, in format `{hostname}:{pid}:{worker_index}`
+	WorkerID string `json:"worker_id"`
+	// nil when this attempt succeeded
+	Error *string `json:"error"`
+	// whether consumer panicked in this attempt
+	Panicked bool `json:"panicked"`
+}
+
+// workerID identifies one consume worker across all pods.
+func workerID(index int) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), index)
+}
+
+// recordAttempt saves one consume attempt within the consume tx.
+func (c consume) recordAttempt(ctx context.Context, tx pgx.Tx, queue Queue, workerID string, startedAt time.Time, errText *string, panicked bool) {
+	if !c.attemptHistory {
+		return
+	}
+	query := `insert into queue_attempts (queue_id, attempt, started_at, finished_at, worker_id, error, panicked)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := tx.Exec(ctx, query, queue.ID, queue.Retry+1, startedAt, time.Now().UTC(), workerID, errText, panicked); err != nil {
+		log.Errorf("MQ: error inserting attempt: (%v)", err)
+	}
+}
+
+// pruneAttempts deletes attempts older than attempt retention.
+func (c consume) pruneAttempts(ctx context.Context) error {
+	query := `delete from queue_attempts where finished_at < $1`
+	if _, err := c.pool.Exec(ctx, query, time.Now().UTC().Add(-c.attemptRetention)); err != nil {
+		return fmt.Errorf("error pruning attempts: %w", err)
+	}
+	return nil
+}
+
+func (a admin) Attempts(ctx context.Context, queueID int64) ([]Attempt, error) {
+	attempts := []Attempt{}
+	query := `select id, queue_id, attempt, started_at, finished_at, worker_id, error, panicked
+		from queue_attempts where queue_id = $1 order by attempt, id`
+	if err := pgxscan.Select(ctx, a.pool, &attempts, query, queueID); err != nil {
+		return nil, fmt.Errorf("error selecting attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+func (a admin) FailedAttempts(ctx context.Context, afterID int64, limit int) ([]Attempt, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	attempts := []Attempt{}
+	query := `select id, queue_id, attempt, started_at, finished_at, worker_id, error, panicked
+		from queue_attempts where id > $1 and error is not null order by id limit $2`
+	if err := pgxscan.Select(ctx, a.pool, &attempts, query, afterID, limit); err != nil {
+		return nil, fmt.Errorf("error selecting failed attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+func (a admin) LastAttemptID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := a.pool.QueryRow(ctx, `select coalesce(max(id), 0) from queue_attempts`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("error selecting last attempt id: %w", err)
+	}
+	return id, nil
+}
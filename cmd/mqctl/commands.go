@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/smiletrl/mq"
+)
+
+// stringsFlag collects one repeated string flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// timeFlag parses one RFC3339 time flag.
+type timeFlag struct {
+	t *time.Time
+}
+
+func (f timeFlag) String() string {
+	if f.t == nil || f.t.IsZero() {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return errors.New("expecting RFC3339 time, such as 2024-01-02T15:04:05Z")
+	}
+	*f.t = t
+	return nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func newTabWriter() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func stats(ctx context.Context, admin mq.Admin) error {
+	stats, err := admin.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	events := make(map[string]mq.QueueStats, len(stats.Events))
+	for event, s := range stats.Events {
+		events[event.String()] = s
+	}
+
+	w := newTabWriter()
+	printStats(w, "CONSUMER", stats.Consumers)
+	fmt.Fprintln(w)
+	printStats(w, "EVENT", events)
+	return w.Flush()
+}
+
+func printStats(w *tabwriter.Writer, header string, stats map[string]mq.QueueStats) {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "%s\tDUE\tSCHEDULED\tIN RETRY\tDEAD\tLAG\tAVG RETRY\n", header)
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%.2f\n", name, s.Due, s.Scheduled, s.InRetry, s.Dead,
+			s.OldestDueAge.Round(time.Second), s.AvgRetry)
+	}
+}
+
+func list(ctx context.Context, admin mq.Admin, args []string) error {
+	var (
+		filter mq.MessageFilter
+		event  string
+	)
+	fs := newFlagSet("list")
+	fs.StringVar(&filter.ConsumerName, "consumer", "", "only messages of this consumer")
+	fs.StringVar(&event, "event", "", "only messages of this event")
+	fs.Int64Var(&filter.AfterID, "after", 0, "only messages after this queue id")
+	fs.IntVar(&filter.Limit, "limit", 100, "max number of messages")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filter.Event = mq.Event(event)
+
+	queues, err := admin.Messages(ctx, filter)
+	if err != nil {
+		return err
+	}
+	return printQueues(queues)
+}
+
+func printQueues(queues []mq.Queue) error {
+	w := newTabWriter()
+	fmt.Fprintln(w, "ID\tCONSUMER\tEVENT\tRETRY\tCHECK AT\tFAILED REASON")
+	for _, q := range queues {
+		var reason string
+		if q.FailedReason != nil {
+			reason = *q.FailedReason
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\n", q.ID, q.ConsumerName, q.Message.MQEvent, q.Retry,
+			q.CheckAt.Format(time.RFC3339), reason)
+	}
+	return w.Flush()
+}
+
+func show(ctx context.Context, admin mq.Admin, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: mqctl show ID")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[0])
+	}
+	queue, err := admin.Message(ctx, id)
+	if err != nil {
+		return err
+	}
+	attempts, err := admin.Attempts(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printJSON(struct {
+		Message  *mq.Queue    `json:"message"`
+		Attempts []mq.Attempt `json:"attempts"`
+	}{queue, attempts})
+}
+
+// deadFlags registers dead message filter flags.
+func deadFlags(fs *flag.FlagSet, filter *mq.DeadFilter, event *string) {
+	fs.StringVar(&filter.ConsumerName, "consumer", "", "only dead messages of this consumer")
+	fs.StringVar(event, "event", "", "only dead messages of this event")
+	fs.StringVar(&filter.Reason, "reason", "", "only dead messages whose failed reason contains this substring")
+	fs.Var(timeFlag{&filter.From}, "from", "only dead messages created at or after this time")
+	fs.Var(timeFlag{&filter.To}, "to", "only dead messages created before this time")
+}
+
+func dead(ctx context.Context, admin mq.Admin, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: mqctl dead list|requeue|purge")
+	}
+	var (
+		filter mq.DeadFilter
+		event  string
+	)
+	sub := args[0]
+	fs := newFlagSet("dead " + sub)
+	deadFlags(fs, &filter, &event)
+	if sub == "list" {
+		fs.IntVar(&filter.Limit, "limit", 100, "max number of messages")
+		fs.IntVar(&filter.Offset, "offset", 0, "number of messages to skip")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	filter.Event = mq.Event(event)
+
+	ids := make([]int64, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	var (
+		count int64
+		err   error
+	)
+	switch sub {
+	case "list":
+		queues, err := admin.DeadMessages(ctx, filter)
+		if err != nil {
+			return err
+		}
+		return printQueues(queues)
+	case "requeue":
+		if len(ids) > 0 {
+			count, err = admin.RequeueDead(ctx, ids...)
+		} else if filter == (mq.DeadFilter{}) {
+			return errors.New("refusing to requeue all dead messages, give ids or one filter")
+		} else {
+			count, err = admin.RequeueDeadByFilter(ctx, filter)
+		}
+	case "purge":
+		if len(ids) > 0 {
+			count, err = admin.PurgeDead(ctx, ids...)
+		} else if filter == (mq.DeadFilter{}) {
+			return errors.New("refusing to purge all dead messages, give ids or one filter")
+		} else {
+			count, err = admin.PurgeDeadByFilter(ctx, filter)
+		}
+	default:
+		return fmt.Errorf("unknown dead command %q", sub)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d dead message(s) %sd\n", count, sub)
+	return nil
+}
+
+func send(ctx context.Context, admin mq.Admin, args []string) error {
+	var (
+		event     string
+		consumers stringsFlag
+		at        = time.Now()
+	)
+	fs := newFlagSet("send")
+	fs.StringVar(&event, "event", "", "event of this message")
+	fs.Var(&consumers, "consumer", "consumer to deliver this message to, repeatable")
+	fs.Var(timeFlag{&at}, "at", "when to consume this message, default now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if event == "" || len(consumers) == 0 || fs.NArg() != 1 {
+		return errors.New("usage: mqctl send -event E -consumer C [-at TIME] PAYLOAD")
+	}
+
+	msg := new(mq.MQMessage)
+	if err := json.Unmarshal([]byte(fs.Arg(0)), msg); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	msg.MQEvent = mq.Event(event)
+
+	ids, err := admin.Enqueue(ctx, msg, consumers, at)
+	if err != nil {
+		return err
+	}
+	return printJSON(ids)
+}
+
+func replay(ctx context.Context, admin mq.Admin, args []string) error {
+	var (
+		req   mq.ReplayRequest
+		event string
+		file  string
+	)
+	fs := newFlagSet("replay")
+	fs.StringVar(&req.ConsumerName, "consumer", "", "consumer to re-enqueue events for")
+	fs.StringVar(&event, "event", "", "event to replay, default is the consumer's event")
+	fs.StringVar(&req.SourceConsumerName, "source", "", "replay every archived message of this consumer, instead of each distinct payload and created time once")
+	fs.Var(timeFlag{&req.From}, "from", "only replay events created at or after this time")
+	fs.Var(timeFlag{&req.To}, "to", "only replay events created before this time")
+	fs.IntVar(&req.Rate, "rate", 0, "max messages enqueued per second, 0 means unlimited")
+	fs.BoolVar(&req.DryRun, "dry-run", false, "only count messages to be replayed")
+	fs.StringVar(&file, "file", "", "replay JSON lines message payloads from this file, instead of archived events")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	req.Event = mq.Event(event)
+	if req.ConsumerName == "" {
+		return errors.New("usage: mqctl replay -consumer C [-event E] ...")
+	}
+	// mqctl doesn't register consumers, so the event is required
+	if file == "" && req.Event == "" {
+		return errors.New("replay event is required")
+	}
+
+	var (
+		count int64
+		err   error
+	)
+	if file != "" {
+		var messages []mq.Message
+		if messages, err = readMessages(file); err != nil {
+			return err
+		}
+		if req.DryRun {
+			count = int64(len(messages))
+		} else {
+			count, err = admin.ReplayMessages(ctx, req.ConsumerName, messages, req.Rate)
+		}
+	} else {
+		count, err = admin.Replay(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	if req.DryRun {
+		fmt.Printf("%d message(s) to be replayed\n", count)
+	} else {
+		fmt.Printf("%d message(s) replayed\n", count)
+	}
+	return nil
+}
+
+// readMessages reads one message payload per line.
+func readMessages(file string) ([]mq.Message, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var messages []mq.Message
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		msg := new(mq.MQMessage)
+		if err := json.Unmarshal(scanner.Bytes(), msg); err != nil {
+			return nil, fmt.Errorf("invalid message at line %d: %w", line, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, scanner.Err()
+}
+
+func rename(ctx context.Context, admin mq.Admin, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: mqctl rename FROM TO")
+	}
+	count, err := admin.RenameConsumer(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d message(s) renamed\n", count)
+	return nil
+}
+
+func export(ctx context.Context, admin mq.Admin, args []string) error {
+	var (
+		filter mq.ExportFilter
+		event  string
+		file   string
+	)
+	fs := newFlagSet("export")
+	fs.StringVar(&filter.ConsumerName, "consumer", "", "only messages of this consumer")
+	fs.StringVar(&event, "event", "", "only messages of this event")
+	fs.BoolVar(&filter.Dead, "dead", false, "export dead messages instead of live messages")
+	fs.IntVar(&filter.Limit, "limit", 0, "max number of messages, 0 means all")
+	fs.StringVar(&file, "file", "", "write to this file, default stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filter.Event = mq.Event(event)
+
+	w := os.Stdout
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	count, err := admin.Export(ctx, w, filter)
+	if err != nil {
+		return err
+	}
+	if file != "" {
+		fmt.Printf("%d message(s) exported\n", count)
+	}
+	return nil
+}
+
+func importMessages(ctx context.Context, admin mq.Admin, args []string) error {
+	var opts mq.ImportOptions
+	fs := newFlagSet("import")
+	fs.BoolVar(&opts.KeepRetry, "keep-retry", false, "keep retry, dead state and failed reason of each message")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: mqctl import [-keep-retry] FILE")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	count, err := admin.Import(ctx, f, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d message(s) imported\n", count)
+	return nil
+}
+
+func tail(ctx context.Context, admin mq.Admin, args []string) error {
+	var interval time.Duration
+	fs := newFlagSet("tail")
+	fs.DurationVar(&interval, "interval", time.Second, "poll interval")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// skip existing messages and attempts, only stream new ones
+	lastID, err := admin.LastMessageID(ctx)
+	if err != nil {
+		return err
+	}
+	lastAttemptID, err := admin.LastAttemptID(ctx)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		queues, err := admin.Messages(ctx, mq.MessageFilter{AfterID: lastID, Limit: 1000})
+		if err != nil {
+			return err
+		}
+		for _, q := range queues {
+			fmt.Printf("%s new     #%d %s %s\n", time.Now().Format(time.RFC3339), q.ID, q.ConsumerName, q.Message.MQEvent)
+			lastID = q.ID
+		}
+
+		attempts, err := admin.FailedAttempts(ctx, lastAttemptID, 1000)
+		if err != nil {
+			return err
+		}
+		for _, a := range attempts {
+			fmt.Printf("%s failed  #%d attempt %d on %s: %s\n", time.Now().Format(time.RFC3339), a.QueueID, a.Attempt,
+				a.WorkerID, *a.Error)
+			lastAttemptID = a.ID
+		}
+	}
+}
@@ -4,14 +4,18 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/labstack/gommon/log"
 )
 
 const (
+	// failed reason of messages which earlier versions marked dead, because their consumer was not registered.
+	// Now these messages are left for one process registering their consumer, see WithOrphanReport.
 	ConsumerNotFound = "consumer not found"
 	MaxRetry         = 5
 )
@@ -31,28 +35,170 @@ type Consume interface {
 	Consume()
 }
 
+// housekeepInterval is how often the consume process runs housekeeping tasks, like pruning expired records.
+const housekeepInterval = time.Minute
+
 type consume struct {
-	pool   *pgxpool.Pool
-	logger Logger
+	pool     *pgxpool.Pool
+	logger   Logger
+	workers  int
+	registry Registry
+
+	// how long finished job status records are kept. Zero keeps them forever.
+	resultRetention time.Duration
+
+	// whether consumed messages are archived instead of deleted
+	archived bool
+	// how long archived messages are kept. Zero keeps them forever.
+	archiveRetention time.Duration
+
+	// whether each consume attempt is saved
+	attemptHistory bool
+	// how long attempts are kept. Zero keeps them forever.
+	attemptRetention time.Duration
+
+	// whether dead messages are moved into table `queue_dead_letters`
+	deadLetterTable bool
+
+	// evaluates alert rules periodically, nil when no alert rule is set
+	alerter *alerter
+
+	// reports orphans at housekeeping, nil when orphan report is off
+	orphans *orphanReporter
+
+	// runs job status updates outside the consume tx, the pool by default. See startJob and resetJob.
+	jobDB execer
+
+	// only consumers matching these selectors are run, see WithInclude and WithExclude
+	include []Selector
+	exclude []Selector
+}
+
+// ConsumeOption configures the consume engine created by NeWConsume.
+type ConsumeOption interface {
+	applyConsume(c *consume)
+}
+
+type consumeOptionFunc func(c *consume)
+
+func (f consumeOptionFunc) applyConsume(c *consume) {
+	f(c)
+}
+
+// WithWorkers sets how many goroutines consume messages concurrently within this process. Default is 1.
+func WithWorkers(workers int) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		if workers > 0 {
+			c.workers = workers
+		}
+	})
 }
 
-func NeWConsume(pool *pgxpool.Pool, logger Logger) Consume {
-	return consume{
-		pool:   pool,
-		logger: logger,
+// WithResultRetention sets how long status records of succeeded, dead or canceled tracked messages are kept.
+// By default, they are kept forever.
+func WithResultRetention(retention time.Duration) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.resultRetention = retention
+	})
+}
+
+// WithArchive moves successfully consumed messages into table `queue_archives` instead of deleting them,
+// which is helpful for audits and replays. Archived messages older than retention are pruned periodically.
+// Zero retention keeps them forever.
+func WithArchive(retention time.Duration) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.archived = true
+		c.archiveRetention = retention
+	})
+}
+
+// WithAttemptHistory saves each consume attempt into table `queue_attempts`, i.e, attempt number, start and finish
+// time, worker id, error and whether consumer panicked. Attempts older than retention are pruned periodically.
+// Zero retention keeps them forever. See Admin.Attempts.
+func WithAttemptHistory(retention time.Duration) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.attemptHistory = true
+		c.attemptRetention = retention
+	})
+}
+
+// WithDeadLetterTable moves dead messages into table `queue_dead_letters` instead of keeping them in table `queues`
+// with is_dead = true, so table `queues` only holds live messages. Admin's dead message operations work with both.
+func WithDeadLetterTable() ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.deadLetterTable = true
+	})
+}
+
+// WithAlerts evaluates alert rules against Admin.Stats every interval, and fires one alert to the hook
+// when one threshold is breached, and again when it has recovered. See NewWebhookAlertHook.
+// Every process running consume evaluates rules, so run it in one dedicated process to avoid duplicate alerts.
+func WithAlerts(interval time.Duration, hook AlertHook, rules ...AlertRule) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		if interval > 0 && hook != nil && len(rules) > 0 {
+			c.alerter = newAlerter(interval, hook, rules)
+		}
+	})
+}
+
+// WithOrphanReport reports consumer names whose messages have been due longer than grace, while no consumer
+// with this name is registered in current process. Such messages are never claimed by this process, and wait for
+// one process registering their consumer. Each orphan is reported to the hook once, until its messages are gone.
+// Nil hook logs orphans.
+func WithOrphanReport(grace time.Duration, hook OrphanHook) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.orphans = newOrphanReporter(grace, hook)
+	})
+}
+
+// WithInclude only runs consumers matching any of the selectors in this process, such as dedicating one worker
+// deployment to one service or heavy consumers. Messages of other consumers are left for other processes.
+// It may be set multiple times, and consumers matching any selector are run.
+func WithInclude(selectors ...Selector) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.include = append(c.include, selectors...)
+	})
+}
+
+// WithExclude doesn't run consumers matching any of the selectors in this process, even if they match WithInclude.
+func WithExclude(selectors ...Selector) ConsumeOption {
+	return consumeOptionFunc(func(c *consume) {
+		c.exclude = append(c.exclude, selectors...)
+	})
+}
+
+func NeWConsume(pool *pgxpool.Pool, logger Logger, opts ...ConsumeOption) Consume {
+	c := consume{
+		pool:     pool,
+		logger:   logger,
+		workers:  1,
+		registry: defaultRegistry,
+		jobDB:    pool,
+	}
+	for _, opt := range opts {
+		opt.applyConsume(&c)
 	}
+	return c
 }
 
 type Queue struct {
-	ID int64
+	ID int64 `json:"id"`
 	// consumer name
-	ConsumerName string
-	Message      MQMessage
-	Retry        int
-	IsDead       bool
-	FailedReason *string
-	CheckAt      time.Time
-	CreatedAT    time.Time
+	ConsumerName string    `json:"consumer_name"`
+	Message      MQMessage `json:"message"`
+	Retry        int       `json:"retry"`
+	IsDead       bool      `json:"is_dead"`
+	FailedReason *string   `json:"failed_reason"`
+	CheckAt      time.Time `json:"check_at"`
+	CreatedAT    time.Time `json:"created_at"`
+	// messages with the same ordering key are consumed in enqueue order
+	OrderingKey *string `json:"ordering_key"`
+	// caller supplied key to cancel or reschedule this message
+	ScheduleKey *string `json:"schedule_key"`
+	// pending message with the same coalescing key is replaced by new message
+	CoalesceKey *string `json:"coalesce_key"`
+	// whether this message has one status record at table `queue_jobs`
+	Tracked bool `json:"tracked"`
 }
 
 func (c consume) Consume() {
@@ -60,16 +206,119 @@ func (c consume) Consume() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	// running status of tracked messages is saved with one more connection besides the consume tx, see startJob
+	if maxConns := int(c.pool.Config().MaxConns); c.workers >= maxConns {
+		log.Warnf("MQ: %d workers use all %d pool connections, set pool max conns above workers", c.workers, maxConns)
+	}
+
+	// all workers share the same scheduler, so consumers take turns across the whole worker capacity.
+	sched := newScheduler(c.registry, c.selected)
+
+	go c.housekeep()
+	if c.alerter != nil {
+		go c.alerter.run(NewAdmin(c.pool, WithRegistry(c.registry)))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func(workerID string) {
+			defer wg.Done()
+			c.work(sched, workerID)
+		}(workerID(i))
+	}
+	wg.Wait()
+}
+
+// housekeep runs housekeeping tasks periodically.
+func (c consume) housekeep() {
+	ticker := time.NewTicker(housekeepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.housekeepOnce()
+	}
+}
+
+func (c consume) housekeepOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := c.resetStaleJobs(ctx); err != nil {
+		log.Errorf("MQ: housekeeping: (%v)", err)
+	}
+	if c.resultRetention > 0 {
+		if err := c.pruneJobs(ctx); err != nil {
+			log.Errorf("MQ: housekeeping: (%v)", err)
+		}
+	}
+	if c.archived && c.archiveRetention > 0 {
+		if err := c.pruneArchives(ctx); err != nil {
+			log.Errorf("MQ: housekeeping: (%v)", err)
+		}
+	}
+	if c.attemptHistory && c.attemptRetention > 0 {
+		if err := c.pruneAttempts(ctx); err != nil {
+			log.Errorf("MQ: housekeeping: (%v)", err)
+		}
+	}
+	if c.orphans != nil {
+		if err := c.orphans.report(ctx, c); err != nil {
+			log.Errorf("MQ: housekeeping: (%v)", err)
+		}
+	}
+}
+
+// work keeps consuming messages in the order given by the scheduler.
+func (c consume) work(sched *scheduler, workerID string) {
+	var misses int
 	for {
-		if sleep := c.consumeSingleMessage(); sleep {
-			// sleep between 0-3 seconds
-			r := rand.Intn(3)
-			time.Sleep(time.Duration(r) * time.Second)
+		if sleep := c.consumeSingleMessage(sched.next(), workerID); !sleep {
+			misses = 0
+			continue
+		}
+		// only sleep when one whole round of consumers has nothing to consume
+		misses++
+		if misses >= sched.size() {
+			misses = 0
+			// once idle, one single query checks due messages of all consumers, instead of one claim tx per
+			// consumer, so idle load doesn't grow with the number of consumers
+			for {
+				// sleep between 0-3 seconds
+				r := rand.Intn(3)
+				time.Sleep(time.Duration(r) * time.Second)
+				if c.anyDue(sched.claimNames()) {
+					break
+				}
+			}
 		}
 	}
 }
 
-func (c consume) consumeSingleMessage() (sleep bool) {
+// anyDue returns whether one live message of given consumer names is due.
+func (c consume) anyDue(names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var due bool
+	query := `select exists (select 1 from queues where is_dead = false and check_at < $1 and consumer_name = any($2))`
+	if err := c.pool.QueryRow(ctx, query, time.Now().UTC(), names).Scan(&due); err != nil {
+		log.Errorf("MQ: error checking due messages: (%v)", err)
+		return false
+	}
+	return due
+}
+
+// consumeSingleMessage consumes one due message of the given consumer.
+func (c consume) consumeSingleMessage(consumerName string, workerID string) (sleep bool) {
+	// no consumer is registered
+	if consumerName == "" {
+		return true
+	}
+
 	// catch possible panic
 	defer func() {
 		if r := recover(); r != nil {
@@ -80,6 +329,14 @@ func (c consume) consumeSingleMessage() (sleep bool) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
+	// the consumer's messages may be sent with its former names, see AliasedConsumer
+	consumer, ok := c.registry.Consumer(consumerName)
+	if !ok {
+		// unregistered since scheduled
+		sleep = true
+		return
+	}
+
 	tx, err := c.pool.Begin(ctx)
 	if err != nil {
 		log.Errorf("MQ: error starting tx at consume: (%v)", err)
@@ -88,12 +345,20 @@ func (c consume) consumeSingleMessage() (sleep bool) {
 	}
 	defer tx.Rollback(ctx)
 
-	// get the single queue
+	// get the single queue. The oldest due message goes first.
+	// A message with ordering key waits until all earlier live messages with the same key and consumer are gone.
+	// An earlier message being consumed is locked, yet still visible here, so same key messages never run in parallel.
 	queues := []Queue{}
-	query := `select * from queues where is_dead = false and check_at < $1 limit 1 for update skip locked`
+	query := `select * from queues where is_dead = false and check_at < $1 and consumer_name = any($2)
+		and (ordering_key is null or not exists (
+			select 1 from queues prior where prior.consumer_name = any($2)
+			and prior.ordering_key = queues.ordering_key and prior.id < queues.id and prior.is_dead = false
+		))
+		order by check_at, id limit 1 for update skip locked`
 
-	if err := pgxscan.Select(ctx, tx, &queues, query, time.Now()); err != nil {
+	if err := pgxscan.Select(ctx, tx, &queues, query, time.Now().UTC(), claimNames(consumer)); err != nil {
 		log.Errorf("MQ: error selecting message at consume: (%v)", err)
+		sleep = true
 		return
 	}
 	if len(queues) == 0 {
@@ -101,47 +366,39 @@ func (c consume) consumeSingleMessage() (sleep bool) {
 		return
 	}
 
-	// get this message's consumer
 	queue := queues[0]
-	consumer, ok := consumers[queue.ConsumerName]
-	if !ok {
-		log.Errorf("MQ: consumer is not found: %s", queue.ConsumerName)
-
-		sql := "update queues set is_dead = true, failed_reason = $1 where id = $2"
-		if _, err := tx.Exec(ctx, sql, ConsumerNotFound, queue.ID); err != nil {
-			log.Errorf("MQ: error setting message to be dead: %w", err)
-		}
-		// commit tx
-		if err := tx.Commit(ctx); err != nil {
-			log.Errorf("MQ: error committing tx: %w", err)
-		}
-		return
-	}
+	c.startJob(queue)
+	startedAt := time.Now().UTC()
 
 	// consumer will use a nested transaction, so if the nested transaction within the consumer has
 	// failed, we can still log the retry or failed reason in the outer transaction.
 	nestTx, err := tx.Begin(ctx)
 	if err != nil {
-		log.Errorf("MQ: error begining nested tx: %w", err)
+		log.Errorf("MQ: error begining nested tx: (%v)", err)
+		c.resetJob(queue)
 		return
 	}
 
 	// consume this message
-	if consumeErr := consumer.Consume(ctx, nestTx, &queue.Message); consumeErr != nil {
+	result, panicked, consumeErr := runConsumer(ctx, consumer, nestTx, &queue.Message)
+	if consumeErr != nil {
 		log.Errorf("MQ: consume message failed with error: (%v)", consumeErr)
 
 		// rollback the nested transaction
 		if err := nestTx.Rollback(ctx); err != nil {
 			// if this error happens, something fatal happens, this message will be processed infinitely.
-			log.Errorf("MQ: error rolling back nested tx: %w", err)
+			log.Errorf("MQ: error rolling back nested tx: (%v)", err)
+			c.resetJob(queue)
 			return
 		}
+		reason := consumeErr.Error()
+		c.recordAttempt(ctx, tx, queue, workerID, startedAt, &reason, panicked)
 		if queue.Retry+1 >= MaxRetry {
 			// max retry reached, set this message to be dead
-			sql := `update queues set retry = retry + 1, is_dead = true, failed_reason = $1 where id = $2`
-			if _, err := tx.Exec(ctx, sql, consumeErr.Error(), queue.ID); err != nil {
+			if err := c.markDead(ctx, tx, queue, reason, 1); err != nil {
 				log.Errorf("MQ: error updating queues with retry and is_dead: (%v)", err)
 			}
+			c.finishJob(ctx, tx, queue, JobStatusDead, nil, &reason)
 		} else {
 			// increase this retry, and check it later
 			sql := `update queues set retry = retry + 1, failed_reason = $1, check_at = $2 where id = $3`
@@ -149,27 +406,59 @@ func (c consume) consumeSingleMessage() (sleep bool) {
 			if !ok {
 				delay = 10 * time.Second
 			}
-			if _, err := tx.Exec(ctx, sql, consumeErr.Error(), time.Now().Add(delay), queue.ID); err != nil {
+			if _, err := tx.Exec(ctx, sql, reason, time.Now().UTC().Add(delay), queue.ID); err != nil {
 				log.Errorf("MQ: error updating queues with retry: (%v)", err)
 			}
+			c.finishJob(ctx, tx, queue, JobStatusFailed, nil, &reason)
 		}
-		// commit tx
-		if err := tx.Commit(ctx); err != nil {
-			log.Errorf("MQ: error committing tx")
-		}
+		c.commit(ctx, tx, queue)
 		return
 	}
 
-	// delete this message if all goes well
-	sql := `delete from queues where id = $1`
-	if _, err := tx.Exec(ctx, sql, queue.ID); err != nil {
-		log.Errorf("MQ: delete queue with error: (%v)", err)
-		return
+	// delete or archive this message if all goes well
+	if c.archived {
+		if err := c.archiveMessage(ctx, tx, queue, startedAt); err != nil {
+			log.Errorf("MQ: archive queue with error: (%v)", err)
+			c.resetJob(queue)
+			return
+		}
+	} else {
+		sql := `delete from queues where id = $1`
+		if _, err := tx.Exec(ctx, sql, queue.ID); err != nil {
+			log.Errorf("MQ: delete queue with error: (%v)", err)
+			c.resetJob(queue)
+			return
+		}
 	}
+	c.finishJob(ctx, tx, queue, JobStatusSucceeded, result, nil)
+	c.recordAttempt(ctx, tx, queue, workerID, startedAt, nil, false)
+	c.commit(ctx, tx, queue)
+	return sleep
+}
 
-	// commit tx
+// commit commits the consume tx of one claimed message. When commit fails, the message stays as it was before
+// claimed, so its status record is reset to pending as well.
+func (c consume) commit(ctx context.Context, tx pgx.Tx, queue Queue) {
 	if err := tx.Commit(ctx); err != nil {
-		log.Errorf("MQ: error committing tx")
+		log.Errorf("MQ: error committing tx: (%v)", err)
+		c.resetJob(queue)
 	}
-	return sleep
+}
+
+// runConsumer calls the consumer, and turns one panic into an error, so panicking messages are retried
+// and become dead like failed messages.
+func runConsumer(ctx context.Context, consumer Consumer, tx pgx.Tx, msg *MQMessage) (result interface{}, panicked bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			err = fmt.Errorf("panic: %+v", r)
+		}
+	}()
+
+	if rc, ok := consumer.(ResultConsumer); ok {
+		result, err = rc.ConsumeResult(ctx, tx, msg)
+	} else {
+		err = consumer.Consume(ctx, tx, msg)
+	}
+	return result, panicked, err
 }
@@ -2,19 +2,11 @@ package mq
 
 import (
 	"context"
-	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v4"
 )
 
-var (
-	consumerMu sync.RWMutex
-
-	// new consumer should register
-	consumers = make(map[string]Consumer)
-)
-
 type Consumer interface {
 	// consumer name. one recommended format `{service_name}:{internal_name}:{event_name}`
 	Name() string
@@ -29,11 +21,47 @@ type Consumer interface {
 	Consume(ctx context.Context, tx pgx.Tx, msg *MQMessage) error
 }
 
+// ResultConsumer is one optional interface a Consumer may implement to save one result for tracked messages,
+// see WithJob. When implemented, ConsumeResult is called instead of Consume. The result is saved as json.
+type ResultConsumer interface {
+	ConsumeResult(ctx context.Context, tx pgx.Tx, msg *MQMessage) (result interface{}, err error)
+}
+
+// AliasedConsumer is one optional interface a Consumer may implement to declare its former names, i.e,
+// when one consumer is renamed, messages sent with its former names are still consumed by it.
+// See Admin.RenameConsumer to rewrite pending messages to the new name.
+type AliasedConsumer interface {
+	Aliases() []string
+}
+
+// RegisterConsumer registers the consumer into the default registry, which is used by NewProvider, NeWConsume and
+// NewAdmin without WithRegistry. It panics when the consumer's name or aliases are registered already.
 func RegisterConsumer(consumer Consumer) {
-	consumerMu.Lock()
-	defer consumerMu.Unlock()
-	if _, dup := consumers[consumer.Name()]; dup {
-		panic("consumer register called twice for " + consumer.Name())
+	defaultRegistry.Register(consumer)
+}
+
+// UnregisterConsumer removes the consumer by name from the default registry.
+// It returns whether the consumer was registered.
+func UnregisterConsumer(name string) bool {
+	return defaultRegistry.Unregister(name)
+}
+
+// consumerAliasNames returns the consumer's aliases, without empty names.
+func consumerAliasNames(consumer Consumer) []string {
+	ac, ok := consumer.(AliasedConsumer)
+	if !ok {
+		return nil
 	}
-	consumers[consumer.Name()] = consumer
+	var aliases []string
+	for _, alias := range ac.Aliases() {
+		if alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// claimNames returns the consumer's name and aliases, i.e, all consumer names of messages the consumer consumes.
+func claimNames(consumer Consumer) []string {
+	return append([]string{consumer.Name()}, consumerAliasNames(consumer)...)
 }
@@ -0,0 +1,46 @@
+package mq
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// testConsumer is one consumer whose optional interfaces return its fields.
+type testConsumer struct {
+	name    string
+	event   Event
+	delay   time.Duration
+	weight  int
+	tags    []string
+	aliases []string
+}
+
+func (c testConsumer) Name() string {
+	return c.name
+}
+
+func (c testConsumer) Event() Event {
+	return c.event
+}
+
+func (c testConsumer) Delay() time.Duration {
+	return c.delay
+}
+
+func (c testConsumer) Consume(ctx context.Context, tx pgx.Tx, msg *MQMessage) error {
+	return nil
+}
+
+func (c testConsumer) Weight() int {
+	return c.weight
+}
+
+func (c testConsumer) Tags() []string {
+	return c.tags
+}
+
+func (c testConsumer) Aliases() []string {
+	return c.aliases
+}
@@ -0,0 +1,372 @@
+package mq
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewDashboard returns one http.Handler serving one HTML dashboard at `/`, plus its JSON API:
+//
+//	GET  /api/overview           stats per consumer and event, the oldest due message, retry distribution
+//	GET  /api/dead               dead messages, filtered by query consumer, event, reason, from, to, limit, offset
+//	POST /api/dead/requeue       requeue dead messages, with json body {"ids": [1, 2]}
+//	POST /api/dead/purge         purge dead messages, with json body {"ids": [1, 2]}
+//	GET  /api/messages/{id}      one message with its attempt history
+//
+// Paths are relative to where the dashboard is mounted, so strip the mount prefix, i.e, with echo
+//
+//	dashboard := echo.WrapHandler(http.StripPrefix("/api/mq", mq.NewDashboard(admin)))
+//	group.Any("/mq", dashboard)
+//	group.Any("/mq/*", dashboard)
+//
+// The mount path without one trailing slash, such as `/api/mq`, is redirected to `/api/mq/`.
+//
+// The dashboard has no authentication. Mount it behind your own admin authentication. POST requests must have
+// content type application/json, which browsers never send cross site without one CORS preflight.
+func NewDashboard(admin Admin) http.Handler {
+	return dashboard{
+		admin: admin,
+	}
+}
+
+type dashboard struct {
+	admin Admin
+}
+
+type idsRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+type countResponse struct {
+	Count int64 `json:"count"`
+}
+
+type messageResponse struct {
+	Message  *Queue    `json:"message"`
+	Attempts []Attempt `json:"attempts"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (d dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := "/" + strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case path == "/" && r.Method == http.MethodGet:
+		if p := requestPath(r); p != "" && !strings.HasSuffix(p, "/") {
+			redirectSlash(w, r)
+			return
+		}
+		d.index(w, r)
+	case path == "/api/overview" && r.Method == http.MethodGet:
+		d.overview(w, r)
+	case path == "/api/dead" && r.Method == http.MethodGet:
+		d.deadMessages(w, r)
+	case path == "/api/dead/requeue" && r.Method == http.MethodPost:
+		d.requeue(w, r)
+	case path == "/api/dead/purge" && r.Method == http.MethodPost:
+		d.purge(w, r)
+	case strings.HasPrefix(path, "/api/messages/") && r.Method == http.MethodGet:
+		d.message(w, r, strings.TrimPrefix(path, "/api/messages/"))
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+// requestPath returns the path requested by the client, before any prefix is stripped, such as by http.StripPrefix.
+func requestPath(r *http.Request) string {
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		return u.Path
+	}
+	return r.URL.Path
+}
+
+// redirectSlash redirects the mount path without one trailing slash, such as `/api/mq`, to the mount path with
+// one trailing slash, since the page fetches its API with relative urls. The location is relative to the last
+// path segment, since the mount prefix may have been stripped from r.URL.Path.
+func redirectSlash(w http.ResponseWriter, r *http.Request) {
+	p := requestPath(r)
+	location := "./" + p[strings.LastIndex(p, "/")+1:] + "/"
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+func (d dashboard) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (d dashboard) overview(w http.ResponseWriter, r *http.Request) {
+	overview, err := d.admin.Overview(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, overview)
+}
+
+func (d dashboard) deadMessages(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeadFilter(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	queues, err := d.admin.DeadMessages(r.Context(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, queues)
+}
+
+func (d dashboard) requeue(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeIDs(w, r)
+	if !ok {
+		return
+	}
+	count, err := d.admin.RequeueDead(r.Context(), req.IDs...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+func (d dashboard) purge(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeIDs(w, r)
+	if !ok {
+		return
+	}
+	count, err := d.admin.PurgeDead(r.Context(), req.IDs...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, countResponse{Count: count})
+}
+
+// decodeIDs decodes one ids request body, writing one error response when it fails.
+// Only json bodies are accepted, so browsers send one CORS preflight before any cross site request, and plain
+// html forms of other sites can't requeue or purge messages with cookies of one logged in admin.
+func decodeIDs(w http.ResponseWriter, r *http.Request) (*idsRequest, bool) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "content type must be application/json"})
+		return nil, false
+	}
+	req := new(idsRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return nil, false
+	}
+	return req, true
+}
+
+func (d dashboard) message(w http.ResponseWriter, r *http.Request, rawID string) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid message id"})
+		return
+	}
+	queue, err := d.admin.Message(r.Context(), id)
+	if errors.Is(err, ErrMessageNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	attempts, err := d.admin.Attempts(r.Context(), id)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, messageResponse{Message: queue, Attempts: attempts})
+}
+
+func parseDeadFilter(r *http.Request) (DeadFilter, error) {
+	q := r.URL.Query()
+	filter := DeadFilter{
+		ConsumerName: q.Get("consumer"),
+		Event:        Event(q.Get("event")),
+		Reason:       q.Get("reason"),
+	}
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid from, expecting RFC3339 time")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("invalid to, expecting RFC3339 time")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return filter, errors.New("invalid limit")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil {
+			return filter, errors.New("invalid offset")
+		}
+	}
+	return filter, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// dashboardTemplate is one single page, calling the JSON API with relative paths.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>MQ Dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; margin-top: .5em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; font-size: .9em; }
+th { background: #f4f4f4; }
+.grid { display: flex; gap: 3em; flex-wrap: wrap; }
+pre { background: #f8f8f8; padding: 1em; overflow: auto; }
+a { cursor: pointer; color: #0645ad; }
+</style>
+</head>
+<body>
+<h1>MQ Dashboard</h1>
+
+<div class="grid">
+  <div><h2>Consumers</h2><table id="consumer-stats"></table></div>
+  <div><h2>Events</h2><table id="event-stats"></table></div>
+  <div><h2>Retry distribution</h2><table id="retry-distribution"></table></div>
+</div>
+<h2>Oldest due message</h2>
+<div id="oldest-due"></div>
+
+<h2>Dead messages</h2>
+<form id="dead-filter">
+  <input name="consumer" placeholder="consumer">
+  <input name="event" placeholder="event">
+  <input name="reason" placeholder="reason contains">
+  <button type="submit">Filter</button>
+</form>
+<p>
+  <button id="requeue">Requeue selected</button>
+  <button id="purge">Purge selected</button>
+</p>
+<table id="dead"></table>
+
+<h2>Message detail</h2>
+<pre id="detail">Click one message id to see its detail and attempt history.</pre>
+
+<script>
+function esc(v) {
+  return String(v === null || v === undefined ? "" : v)
+    .replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;");
+}
+
+function fetchJSON(url, options) {
+  return fetch(url, options).then(function (res) {
+    return res.json().then(function (body) {
+      if (!res.ok) { throw new Error(body.error || res.statusText); }
+      return body;
+    });
+  });
+}
+
+function renderMap(id, header, data) {
+  var rows = "<tr><th>" + header + "</th><th>messages</th></tr>";
+  Object.keys(data || {}).sort().forEach(function (k) {
+    rows += "<tr><td>" + esc(k) + "</td><td>" + esc(data[k]) + "</td></tr>";
+  });
+  document.getElementById(id).innerHTML = rows;
+}
+
+function renderStats(id, header, data) {
+  var rows = "<tr><th>" + header + "</th><th>due</th><th>scheduled</th><th>in retry</th><th>dead</th>" +
+    "<th>lag (s)</th><th>avg retry</th></tr>";
+  Object.keys(data || {}).sort().forEach(function (k) {
+    var s = data[k];
+    rows += "<tr><td>" + esc(k) + "</td><td>" + esc(s.due) + "</td><td>" + esc(s.scheduled) + "</td><td>" +
+      esc(s.in_retry) + "</td><td>" + esc(s.dead) + "</td><td>" + esc(Math.round(s.oldest_due_age / 1e9)) +
+      "</td><td>" + esc(s.avg_retry.toFixed(2)) + "</td></tr>";
+  });
+  document.getElementById(id).innerHTML = rows;
+}
+
+function loadOverview() {
+  fetchJSON("api/overview").then(function (o) {
+    renderStats("consumer-stats", "consumer", o.stats.consumers);
+    renderStats("event-stats", "event", o.stats.events);
+    renderMap("retry-distribution", "retry", o.retry_distribution);
+    var due = o.oldest_due;
+    document.getElementById("oldest-due").innerHTML = due
+      ? "<a onclick='showMessage(" + due.id + ")'>#" + due.id + "</a> " + esc(due.consumer_name) + ", due at " + esc(due.check_at)
+      : "No message is due.";
+  }).catch(alert);
+}
+
+function loadDead() {
+  var params = new URLSearchParams(new FormData(document.getElementById("dead-filter")));
+  fetchJSON("api/dead?" + params.toString()).then(function (list) {
+    var rows = "<tr><th></th><th>id</th><th>consumer</th><th>event</th><th>retry</th><th>failed reason</th><th>created at</th></tr>";
+    list.forEach(function (q) {
+      rows += "<tr><td><input type='checkbox' value='" + q.id + "'></td>" +
+        "<td><a onclick='showMessage(" + q.id + ")'>" + q.id + "</a></td>" +
+        "<td>" + esc(q.consumer_name) + "</td><td>" + esc(q.message.event) + "</td><td>" + esc(q.retry) + "</td>" +
+        "<td>" + esc(q.failed_reason) + "</td><td>" + esc(q.created_at) + "</td></tr>";
+    });
+    document.getElementById("dead").innerHTML = rows;
+  }).catch(alert);
+}
+
+function selectedIDs() {
+  return Array.prototype.map.call(document.querySelectorAll("#dead input:checked"), function (el) {
+    return parseInt(el.value, 10);
+  });
+}
+
+function postIDs(url) {
+  var ids = selectedIDs();
+  if (ids.length === 0 || !confirm(url + " " + ids.length + " message(s)?")) { return; }
+  fetchJSON(url, {method: "POST", headers: {"Content-Type": "application/json"}, body: JSON.stringify({ids: ids})})
+    .then(function (res) { alert(res.count + " message(s) affected"); loadOverview(); loadDead(); })
+    .catch(alert);
+}
+
+function showMessage(id) {
+  fetchJSON("api/messages/" + id).then(function (res) {
+    document.getElementById("detail").textContent = JSON.stringify(res, null, 2);
+  }).catch(alert);
+}
+
+document.getElementById("dead-filter").addEventListener("submit", function (e) { e.preventDefault(); loadDead(); });
+document.getElementById("requeue").addEventListener("click", function () { postIDs("api/dead/requeue"); });
+document.getElementById("purge").addEventListener("click", function () { postIDs("api/dead/purge"); });
+loadOverview();
+loadDead();
+</script>
+</body>
+</html>
+`))
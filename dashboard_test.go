@@ -0,0 +1,82 @@
+package mq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// purgeAdmin records purged ids. Other Admin methods are not implemented.
+type purgeAdmin struct {
+	Admin
+	purged []int64
+}
+
+func (a *purgeAdmin) PurgeDead(ctx context.Context, ids ...int64) (int64, error) {
+	a.purged = append(a.purged, ids...)
+	return int64(len(ids)), nil
+}
+
+func TestDashboardPurgeContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		wantStatus  int
+		wantPurged  int
+	}{
+		{"json", "application/json", http.StatusOK, 2},
+		{"json with charset", "application/json; charset=utf-8", http.StatusOK, 2},
+		{"cross site text form", "text/plain", http.StatusUnsupportedMediaType, 0},
+		{"cross site url encoded form", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType, 0},
+		{"missing", "", http.StatusUnsupportedMediaType, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			admin := &purgeAdmin{}
+			req := httptest.NewRequest(http.MethodPost, "/api/dead/purge", strings.NewReader(`{"ids":[1,2]}`))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			NewDashboard(admin).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if len(admin.purged) != tt.wantPurged {
+				t.Errorf("purged %v, want %d ids", admin.purged, tt.wantPurged)
+			}
+		})
+	}
+}
+
+func TestDashboardMountPathRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"mount path without slash", "/api/mq", http.StatusMovedPermanently, "./mq/"},
+		{"mount path without slash with query", "/api/mq?consumer=notify", http.StatusMovedPermanently, "./mq/?consumer=notify"},
+		{"mount path with slash", "/api/mq/", http.StatusOK, ""},
+	}
+
+	handler := http.StripPrefix("/api/mq", NewDashboard(&purgeAdmin{}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+)
+
+// Dead messages either stay in table `queues` with is_dead = true, or are moved into table `queue_dead_letters`
+// when consume runs with WithDeadLetterTable. Below dead message operations work with both tables.
+
+// markDead sets one message to be dead with the failed reason, increasing its retry by retryInc.
+func (c consume) markDead(ctx context.Context, tx pgx.Tx, queue Queue, reason string, retryInc int) error {
+	if !c.deadLetterTable {
+		sql := `update queues set retry = retry + $1, is_dead = true, failed_reason = $2 where id = $3`
+		if _, err := tx.Exec(ctx, sql, retryInc, reason, queue.ID); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	sql := `with moved as (
+			delete from queues where id = $1 returning *
+		)
+		insert into queue_dead_letters (id, consumer_name, message, retry, failed_reason, check_at, created_at,
+			ordering_key, schedule_key, coalesce_key, tracked, dead_at)
+		select id, consumer_name, message, retry + $2, $3, check_at, created_at,
+			ordering_key, schedule_key, coalesce_key, tracked, $4 from moved`
+	if _, err := tx.Exec(ctx, sql, queue.ID, retryInc, reason, time.Now().UTC()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeadFilter filters dead messages. Zero value fields are ignored.
+type DeadFilter struct {
+	// only dead messages of this consumer
+	ConsumerName string
+	// only dead messages of this event
+	Event Event
+	// only dead messages created at or after this time
+	From time.Time
+	// only dead messages created before this time
+	To time.Time
+	// only dead messages whose failed reason contains this substring, case insensitive
+	Reason string
+	// max number of messages to list, default 100. Ignored by requeue and purge.
+	Limit int
+	// number of messages to skip when listing. Ignored by requeue and purge.
+	Offset int
+}
+
+// where builds the where clause matching this filter, appending query args to args.
+// The clause works with both table `queues` and table `queue_dead_letters`, without checking is_dead.
+func (f DeadFilter) where(args []interface{}) (string, []interface{}) {
+	conds := []string{"true"}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ConsumerName != "" {
+		add("consumer_name = $%d", f.ConsumerName)
+	}
+	if f.Event != "" {
+		add("message->>'event' = $%d", f.Event.String())
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To.UTC())
+	}
+	if f.Reason != "" {
+		add("strpos(lower(failed_reason), lower($%d)) > 0", f.Reason)
+	}
+	return strings.Join(conds, " and "), args
+}
+
+func (a admin) DeadMessages(ctx context.Context, filter DeadFilter) ([]Queue, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	where, args := filter.where(nil)
+	args = append(args, limit, filter.Offset)
+	query := fmt.Sprintf(`select * from (
+			select id, consumer_name, message, retry, is_dead, failed_reason, check_at, created_at,
+				ordering_key, schedule_key, coalesce_key, tracked
+			from queues where is_dead = true and %[1]s
+			union all
+			select id, consumer_name, message, retry, true, failed_reason, check_at, created_at,
+				ordering_key, schedule_key, coalesce_key, tracked
+			from queue_dead_letters where %[1]s
+		) dead order by id desc limit $%[2]d offset $%[3]d`, where, len(args)-1, len(args))
+
+	queues := []Queue{}
+	if err := pgxscan.Select(ctx, a.pool, &queues, query, args...); err != nil {
+		return nil, fmt.Errorf("error selecting dead messages: %w", err)
+	}
+	return queues, nil
+}
+
+func (a admin) RequeueDead(ctx context.Context, ids ...int64) (int64, error) {
+	return a.requeue(ctx, "id = any($1)", []interface{}{ids}, nil)
+}
+
+func (a admin) RequeueDeadByFilter(ctx context.Context, filter DeadFilter) (int64, error) {
+	where, args := filter.where(nil)
+	return a.requeue(ctx, where, args, nil)
+}
+
+func (a admin) RequeueDeadWithMessage(ctx context.Context, id int64, message MQMessage) (int64, error) {
+	return a.requeue(ctx, "id = $1", []interface{}{id}, &message)
+}
+
+// requeue makes dead messages matching the where clause alive again, to be consumed right now.
+// Dead letters are moved back into table `queues`, keeping their queue id.
+// Coalescing key is cleared, since one live message may have taken the same key already.
+func (a admin) requeue(ctx context.Context, where string, args []interface{}, message *MQMessage) (int64, error) {
+	args = append(args, time.Now().UTC(), JobStatusPending, message)
+	now, status, msg := len(args)-2, len(args)-1, len(args)
+	query := fmt.Sprintf(`with requeued as (
+			update queues set message = coalesce($%[4]d, message), retry = 0, is_dead = false, check_at = $%[2]d,
+				coalesce_key = null
+			where is_dead = true and %[1]s
+			returning id
+		), moved as (
+			delete from queue_dead_letters where %[1]s returning *
+		), inserted as (
+			insert into queues (id, consumer_name, message, retry, is_dead, failed_reason, check_at, created_at,
+				ordering_key, schedule_key, tracked)
+			select id, consumer_name, coalesce($%[4]d, message), 0, false, failed_reason, $%[2]d, created_at,
+				ordering_key, schedule_key, tracked from moved
+			returning id
+		), jobs as (
+			update queue_jobs set status = $%[3]d, updated_at = $%[2]d
+			where id in (select id from requeued union all select id from inserted)
+		)
+		select (select count(*) from requeued) + (select count(*) from inserted)`, where, now, status, msg)
+
+	var count int64
+	if err := a.pool.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error requeuing dead messages: %w", err)
+	}
+	return count, nil
+}
+
+func (a admin) PurgeDead(ctx context.Context, ids ...int64) (int64, error) {
+	return a.purge(ctx, "id = any($1)", []interface{}{ids})
+}
+
+func (a admin) PurgeDeadByFilter(ctx context.Context, filter DeadFilter) (int64, error) {
+	where, args := filter.where(nil)
+	return a.purge(ctx, where, args)
+}
+
+// purge deletes dead messages matching the where clause from both tables.
+func (a admin) purge(ctx context.Context, where string, args []interface{}) (int64, error) {
+	query := fmt.Sprintf(`with purged as (
+			delete from queues where is_dead = true and %[1]s returning id
+		), purged_letters as (
+			delete from queue_dead_letters where %[1]s returning id
+		)
+		select (select count(*) from purged) + (select count(*) from purged_letters)`, where)
+
+	var count int64
+	if err := a.pool.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error purging dead messages: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,62 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// dedupConsumers saves the idempotency key for each consumer, and returns consumers which have not seen this key
+// within the dedup window. Table `queue_dedup_keys` has one unique constraint on consumer name and key, so
+// duplicate keys do nothing, unless the existing key has expired, in which case the key is taken over again.
+// The key is kept after the message is consumed, so duplicates are still skipped within the window.
+func (p provider) dedupConsumers(ctx context.Context, tx pgx.Tx, consumerGroups []Consumer, key string, now time.Time) ([]Consumer, error) {
+	names := make([]string, 0, len(consumerGroups))
+	for _, consumer := range consumerGroups {
+		names = append(names, consumer.Name())
+	}
+
+	query := `insert into queue_dedup_keys (consumer_name, dedup_key, expires_at)
+		select unnest($1::text[]), $2, $3
+		on conflict (consumer_name, dedup_key) do update set expires_at = excluded.expires_at
+		where queue_dedup_keys.expires_at <= $4
+		returning consumer_name`
+	rows, err := tx.Query(ctx, query, names, key, now.Add(p.dedupWindow), now)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting dedup keys: %w", err)
+	}
+	defer rows.Close()
+
+	fresh := make(map[string]bool, len(names))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning dedup keys: %w", err)
+		}
+		fresh[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error inserting dedup keys: %w", err)
+	}
+
+	result := make([]Consumer, 0, len(fresh))
+	for _, consumer := range consumerGroups {
+		if fresh[consumer.Name()] {
+			result = append(result, consumer)
+		}
+	}
+	return result, nil
+}
+
+// PruneDedupKeys deletes expired idempotency keys. Expired keys are reused anyway, so this only keeps table
+// `queue_dedup_keys` small. Run it periodically, such as in one daily cron.
+func (p provider) PruneDedupKeys(ctx context.Context) (int64, error) {
+	query := `delete from queue_dedup_keys where expires_at <= $1`
+	tag, err := p.pool.Exec(ctx, query, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error pruning dedup keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
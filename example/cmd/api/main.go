@@ -45,6 +45,11 @@ func main() {
 	orderSvc := order.NewService(pool, orderRepo, mqProvider)
 	order.RegisterHandlers(group, orderSvc)
 
+	// mq dashboard, visit localhost:1325/api/mq
+	dashboard := echo.WrapHandler(http.StripPrefix("/api/mq", mq.NewDashboard(mq.NewAdmin(pool))))
+	group.Any("/mq", dashboard)
+	group.Any("/mq/*", dashboard)
+
 	// register consumers
 	notifySvc := notify.NewService()
 	notify.RegisterConsumer(notifySvc)
@@ -71,7 +76,7 @@ func main() {
 
 func shutdown(e *echo.Echo) {
 	// Handle SIGTERM
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	select {
 	case <-ch:
@@ -43,7 +43,7 @@ func (s *service) Create(ctx context.Context, req *createRequest) error {
 	msg := mq.NewOrderMessage(mq.EventOrderCreated).
 		WithOrderID(orderID).
 		Encode(ctx)
-	if err := s.mq.SendMessage(ctx, tx, msg); err != nil {
+	if _, err := s.mq.SendMessage(ctx, tx, msg); err != nil {
 		return fmt.Errorf("error sending mq message: %w", err)
 	}
 
@@ -0,0 +1,183 @@
+package mq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+)
+
+// exportBatchSize is the max number of messages selected, or inserted, in one round trip when exporting or importing.
+const exportBatchSize = 500
+
+// ExportFilter selects messages to export. Zero value fields are ignored.
+type ExportFilter struct {
+	// only messages of this consumer
+	ConsumerName string
+	// only messages of this event
+	Event Event
+	// export dead messages, from both table `queues` and table `queue_dead_letters`, instead of live messages
+	Dead bool
+	// max number of messages to export. Zero means all matching messages.
+	Limit int
+}
+
+// ImportOptions configures how exported messages are imported.
+type ImportOptions struct {
+	// keep retry, dead state and failed reason of each message. By default, imported messages are
+	// fresh live messages with retry 0.
+	KeepRetry bool
+}
+
+func (a admin) Export(ctx context.Context, w io.Writer, filter ExportFilter) (int64, error) {
+	var (
+		conds = "true"
+		args  []interface{}
+	)
+	if filter.ConsumerName != "" {
+		args = append(args, filter.ConsumerName)
+		conds += fmt.Sprintf(" and consumer_name = $%d", len(args))
+	}
+	if filter.Event != "" {
+		args = append(args, filter.Event.String())
+		conds += fmt.Sprintf(" and message->>'event' = $%d", len(args))
+	}
+
+	var source string
+	if filter.Dead {
+		source = fmt.Sprintf(`select id, consumer_name, message, retry, is_dead, failed_reason, check_at, created_at,
+				ordering_key, schedule_key, coalesce_key, tracked
+			from queues where is_dead = true and %[1]s
+			union all
+			select id, consumer_name, message, retry, true, failed_reason, check_at, created_at,
+				ordering_key, schedule_key, coalesce_key, tracked
+			from queue_dead_letters where %[1]s`, conds)
+	} else {
+		source = fmt.Sprintf(`select * from queues where is_dead = false and %s`, conds)
+	}
+	query := fmt.Sprintf(`select * from (%s) s where id > $%d order by id limit $%d`, source, len(args)+1, len(args)+2)
+
+	// page through messages by queue id, so large exports don't hold all messages in memory
+	var (
+		total   int64
+		afterID int64
+		enc     = json.NewEncoder(w)
+	)
+	for filter.Limit <= 0 || total < int64(filter.Limit) {
+		batchSize := exportBatchSize
+		if filter.Limit > 0 && filter.Limit-int(total) < batchSize {
+			batchSize = filter.Limit - int(total)
+		}
+
+		queues := []Queue{}
+		if err := pgxscan.Select(ctx, a.pool, &queues, query, append(args, afterID, batchSize)...); err != nil {
+			return total, fmt.Errorf("error selecting messages to export: %w", err)
+		}
+		for _, queue := range queues {
+			if err := enc.Encode(queue); err != nil {
+				return total, fmt.Errorf("error writing exported message: %w", err)
+			}
+			total++
+			afterID = queue.ID
+		}
+		if len(queues) < batchSize {
+			break
+		}
+	}
+	return total, nil
+}
+
+func (a admin) Import(ctx context.Context, r io.Reader, opts ImportOptions) (int64, error) {
+	tx, err := a.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting tx at import: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		total int64
+		batch []Queue
+		now   = time.Now().UTC()
+	)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var queue Queue
+		if err := json.Unmarshal(scanner.Bytes(), &queue); err != nil {
+			return 0, fmt.Errorf("invalid message at line %d: %w", line, err)
+		}
+		if queue.ConsumerName == "" {
+			return 0, fmt.Errorf("invalid message at line %d: missing consumer name", line)
+		}
+		batch = append(batch, queue)
+		if len(batch) == exportBatchSize {
+			if err := importBatch(ctx, tx, batch, opts, now); err != nil {
+				return 0, err
+			}
+			total += int64(len(batch))
+			batch = batch[:0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading messages to import: %w", err)
+	}
+	if err := importBatch(ctx, tx, batch, opts, now); err != nil {
+		return 0, err
+	}
+	total += int64(len(batch))
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing tx at import: %w", err)
+	}
+	return total, nil
+}
+
+// importBatch inserts exported messages with new queue ids, to be consumed at now. Coalescing key and job
+// tracking are not imported, since they refer to state of the source database.
+func importBatch(ctx context.Context, tx pgx.Tx, queues []Queue, opts ImportOptions, now time.Time) error {
+	if len(queues) == 0 {
+		return nil
+	}
+	query := `insert into queues (consumer_name, message, retry, is_dead, failed_reason, check_at, created_at,
+			ordering_key, schedule_key)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	batch := &pgx.Batch{}
+	for _, queue := range queues {
+		var (
+			retry  int
+			isDead bool
+			reason *string
+		)
+		if opts.KeepRetry {
+			retry, isDead, reason = queue.Retry, queue.IsDead, queue.FailedReason
+		}
+		createdAt := queue.CreatedAT.UTC()
+		if queue.CreatedAT.IsZero() {
+			createdAt = now
+		}
+		batch.Queue(query, queue.ConsumerName, queue.Message, retry, isDead, reason, now, createdAt,
+			queue.OrderingKey, queue.ScheduleKey)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for range queues {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return fmt.Errorf("error importing messages: %w", err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("error importing messages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// insertChunkSize is the max number of rows inserted by one statement, so one huge bulk send never builds one
+// huge statement.
+const insertChunkSize = 5000
+
+// queueRow is one message to be inserted into table `queues` for one consumer.
+type queueRow struct {
+	consumerName string
+	message      Message
+	checkAt      time.Time
+	orderingKey  *string
+	scheduleKey  *string
+	coalesceKey  *string
+	tracked      bool
+}
+
+// insertRows inserts rows with chunked inserts. It returns generated ids in the same order as rows.
+func insertRows(ctx context.Context, tx pgx.Tx, rows []queueRow) ([]int64, error) {
+	ids := make([]int64, 0, len(rows))
+	for start := 0; start < len(rows); start += insertChunkSize {
+		end := start + insertChunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		chunkIDs, err := insertChunk(ctx, tx, rows[start:end])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, chunkIDs...)
+	}
+	return ids, nil
+}
+
+// insertChunk inserts rows passed as one array per column. Postgres doesn't promise the order of rows returned
+// by insert, so ids are allocated before insert with each row's ordinality, then mapped back by that ordinality.
+// One row taking one pending message's coalescing key returns the pending message's id instead.
+func insertChunk(ctx context.Context, tx pgx.Tx, rows []queueRow) ([]int64, error) {
+	var (
+		consumerNames = make([]string, len(rows))
+		messages      = make([]string, len(rows))
+		checkAts      = make([]time.Time, len(rows))
+		orderingKeys  = make([]*string, len(rows))
+		scheduleKeys  = make([]*string, len(rows))
+		coalesceKeys  = make([]*string, len(rows))
+		tracked       = make([]bool, len(rows))
+	)
+	for i, row := range rows {
+		message, err := json.Marshal(row.message)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding message: %w", err)
+		}
+		consumerNames[i], messages[i], checkAts[i] = row.consumerName, string(message), row.checkAt
+		orderingKeys[i], scheduleKeys[i], coalesceKeys[i], tracked[i] = row.orderingKey, row.scheduleKey, row.coalesceKey, row.tracked
+	}
+
+	// one pending message with the same coalescing key takes the new payload and check time, instead of
+	// inserting another message. Messages without coalescing key never conflict. Retry and failed reason belong
+	// to the replaced payload, so they are reset too.
+	query := `with input as (
+			select nextval(pg_get_serial_sequence('queues', 'id')) as id, r.*
+			from unnest($1::text[], $2::text[], $3::timestamp[], $4::text[], $5::text[], $6::text[], $7::boolean[])
+			with ordinality as r(consumer_name, message, check_at, ordering_key, schedule_key, coalesce_key, tracked, ord)
+		), inserted as (
+			insert into queues (id, consumer_name, message, check_at, ordering_key, schedule_key, coalesce_key, tracked)
+			select id, consumer_name, message::jsonb, check_at, ordering_key, schedule_key, coalesce_key, tracked
+			from input order by ord
+			on conflict (consumer_name, coalesce_key) where is_dead = false
+			do update set message = excluded.message, check_at = excluded.check_at, retry = 0, failed_reason = null,
+				tracked = queues.tracked or excluded.tracked
+			returning id, consumer_name, coalesce_key
+		)
+		select input.ord, inserted.id from input join inserted on inserted.id = input.id
+			or (inserted.consumer_name = input.consumer_name and inserted.coalesce_key = input.coalesce_key)`
+
+	dbRows, err := tx.Query(ctx, query, consumerNames, messages, checkAts, orderingKeys, scheduleKeys, coalesceKeys, tracked)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting message queue: %w", err)
+	}
+	defer dbRows.Close()
+
+	ids := make([]int64, len(rows))
+	var count int
+	for dbRows.Next() {
+		var ord, id int64
+		if err := dbRows.Scan(&ord, &id); err != nil {
+			return nil, fmt.Errorf("error scanning message queue id: %w", err)
+		}
+		if ord < 1 || ord > int64(len(rows)) {
+			return nil, fmt.Errorf("error inserting message queue: unexpected ordinality %d", ord)
+		}
+		ids[ord-1] = id
+		count++
+	}
+	if err := dbRows.Err(); err != nil {
+		return nil, fmt.Errorf("error inserting message queue: %w", err)
+	}
+	if count != len(rows) {
+		return nil, fmt.Errorf("error inserting message queue: %d rows inserted, %d expected", count, len(rows))
+	}
+	return ids, nil
+}
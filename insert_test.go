@@ -0,0 +1,94 @@
+package mq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInsertRowsIDs(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	key := "search:product:12"
+
+	// one pending message takes the coalescing key first
+	pending, err := insertRows(ctx, tx, []queueRow{
+		{consumerName: "search:product:product_updated", message: NewOrderMessage(EventOrderCreated), checkAt: now, coalesceKey: &key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []queueRow{
+		{consumerName: "notify:order:order_created", message: NewOrderMessage(EventOrderCreated).WithOrderID(1), checkAt: now},
+		{consumerName: "search:product:product_updated", message: NewOrderMessage(EventOrderCreated).WithOrderID(2), checkAt: now, coalesceKey: &key},
+		{consumerName: "notify:order:order_created", message: NewOrderMessage(EventOrderCreated).WithOrderID(3), checkAt: now},
+		{consumerName: "report:order:order_created", message: NewOrderMessage(EventOrderCreated).WithOrderID(4), checkAt: now},
+	}
+	ids, err := insertRows(ctx, tx, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(rows) {
+		t.Fatalf("insertRows() returned %d ids, want %d", len(ids), len(rows))
+	}
+	if ids[1] != pending[0] {
+		t.Errorf("coalesced row got id %d, want the pending message's id %d", ids[1], pending[0])
+	}
+	// ids follow row order, so messages with one ordering key are consumed in send order
+	if !(ids[0] < ids[2] && ids[2] < ids[3]) {
+		t.Errorf("ids %v don't follow row order", ids)
+	}
+
+	for i, id := range ids {
+		var (
+			consumerName string
+			message      MQMessage
+		)
+		if err := tx.QueryRow(ctx, `select consumer_name, message from queues where id = $1`, id).Scan(&consumerName, &message); err != nil {
+			t.Fatalf("error selecting message %d: %v", id, err)
+		}
+		if consumerName != rows[i].consumerName || message.MQOrderID != int64(i+1) {
+			t.Errorf("row %d got id %d of %s order %d", i, id, consumerName, message.MQOrderID)
+		}
+	}
+}
+
+func TestInsertRowsCoalesceResetsRetry(t *testing.T) {
+	tx := testTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	key := "search:product:12"
+	row := queueRow{consumerName: "search:product:product_updated", message: NewOrderMessage(EventOrderCreated), checkAt: now, coalesceKey: &key}
+
+	ids, err := insertRows(ctx, tx, []queueRow{row})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pending message failed twice, and waits for its next retry
+	if _, err := tx.Exec(ctx, `update queues set retry = 2, failed_reason = 'timeout', check_at = $1 where id = $2`,
+		now.Add(time.Minute), ids[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	row.message = NewOrderMessage(EventOrderCreated).WithOrderID(2)
+	coalesced, err := insertRows(ctx, tx, []queueRow{row})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coalesced[0] != ids[0] {
+		t.Fatalf("coalesced message got id %d, want %d", coalesced[0], ids[0])
+	}
+
+	var (
+		retry  int
+		reason *string
+	)
+	if err := tx.QueryRow(ctx, `select retry, failed_reason from queues where id = $1`, ids[0]).Scan(&retry, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if retry != 0 || reason != nil {
+		t.Errorf("coalesced message has retry %d and failed reason %v, want 0 and none", retry, reason)
+	}
+}
@@ -0,0 +1,168 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// JobStatus is the status of one tracked message.
+type JobStatus string
+
+const (
+	// message is waiting to be consumed
+	JobStatusPending JobStatus = "pending"
+	// message is being consumed
+	JobStatusRunning JobStatus = "running"
+	// message is consumed successfully
+	JobStatusSucceeded JobStatus = "succeeded"
+	// message failed to be consumed, and will be retried
+	JobStatusFailed JobStatus = "failed"
+	// message reached max retry times, yet still failed to be consumed
+	JobStatusDead JobStatus = "dead"
+	// message was canceled before being consumed
+	JobStatusCanceled JobStatus = "canceled"
+)
+
+// ErrJobNotFound is returned when no status record exists for the queue id.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is the status record of one tracked message, saved at table `queue_jobs`.
+type Job struct {
+	// queue id
+	ID           int64     `json:"id"`
+	ConsumerName string    `json:"consumer_name"`
+	Status       JobStatus `json:"status"`
+	// consumer's result, only for ResultConsumer
+	Result json.RawMessage `json:"result"`
+	// last error when consume failed
+	Error     *string   `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// insertJobs creates pending status records for tracked messages. One coalesced message is pending again.
+func insertJobs(ctx context.Context, tx pgx.Tx, rows []queueRow, ids []int64) error {
+	var (
+		jobIDs []int64
+		names  []string
+	)
+	for i, row := range rows {
+		if row.tracked {
+			jobIDs = append(jobIDs, ids[i])
+			names = append(names, row.consumerName)
+		}
+	}
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	query := `insert into queue_jobs (id, consumer_name, status, created_at, updated_at)
+		select unnest($1::bigint[]), unnest($2::text[]), $3, $4, $4
+		on conflict (id) do update set status = excluded.status, result = null, error = null, updated_at = excluded.updated_at`
+	if _, err := tx.Exec(ctx, query, jobIDs, names, JobStatusPending, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error inserting jobs: %w", err)
+	}
+	return nil
+}
+
+func (p provider) Job(ctx context.Context, id int64) (*Job, error) {
+	jobs := []Job{}
+	query := `select id, consumer_name, status, result, error, created_at, updated_at from queue_jobs where id = $1`
+	if err := pgxscan.Select(ctx, p.pool, &jobs, query, id); err != nil {
+		return nil, fmt.Errorf("error selecting job: %w", err)
+	}
+	if len(jobs) == 0 {
+		return nil, ErrJobNotFound
+	}
+	return &jobs[0], nil
+}
+
+// startJobTimeout is how long marking one tracked message as running, or pending again, may wait for one connection.
+const startJobTimeout = 5 * time.Second
+
+// execer runs one statement, such as *pgxpool.Pool.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// startJob marks one tracked message as running. It runs outside the consume tx, so producers can see it
+// while the message is being consumed. It needs one more connection besides the one holding the consume tx,
+// so it has its own short timeout. When the pool is exhausted, the status stays pending, and the consume tx
+// is not affected.
+func (c consume) startJob(queue Queue) {
+	if !queue.Tracked {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startJobTimeout)
+	defer cancel()
+
+	query := `update queue_jobs set status = $1, updated_at = $2 where id = $3`
+	if _, err := c.jobDB.Exec(ctx, query, JobStatusRunning, time.Now().UTC(), queue.ID); err != nil {
+		log.Errorf("MQ: error updating job to be running: (%v)", err)
+	}
+}
+
+// resetJob marks one tracked message as pending again, when its consume tx ends without commit after startJob.
+// If this fails too, resetStaleJobs catches the status at housekeeping.
+func (c consume) resetJob(queue Queue) {
+	if !queue.Tracked {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), startJobTimeout)
+	defer cancel()
+
+	query := `update queue_jobs set status = $1, updated_at = $2 where id = $3 and status = $4`
+	if _, err := c.jobDB.Exec(ctx, query, JobStatusPending, time.Now().UTC(), queue.ID, JobStatusRunning); err != nil {
+		log.Errorf("MQ: error updating job to be pending: (%v)", err)
+	}
+}
+
+// resetStaleJobs marks running status records pending again, when their message is due and not locked by
+// any consume tx, i.e, the consume process died while consuming, or resetJob failed.
+func (c consume) resetStaleJobs(ctx context.Context) error {
+	query := `update queue_jobs set status = $1, updated_at = $2 where status = $3 and id in (
+			select queues.id from queues join queue_jobs jobs on jobs.id = queues.id
+			where jobs.status = $3 and queues.is_dead = false and queues.check_at < $2
+			for update of queues skip locked
+		)`
+	if _, err := c.pool.Exec(ctx, query, JobStatusPending, time.Now().UTC(), JobStatusRunning); err != nil {
+		return fmt.Errorf("error resetting stale jobs: %w", err)
+	}
+	return nil
+}
+
+// finishJob saves the consume result of one tracked message within the consume tx.
+func (c consume) finishJob(ctx context.Context, tx pgx.Tx, queue Queue, status JobStatus, result interface{}, errText *string) {
+	if !queue.Tracked {
+		return
+	}
+	var resultJSON []byte
+	if result != nil {
+		var err error
+		if resultJSON, err = json.Marshal(result); err != nil {
+			log.Errorf("MQ: error encoding job result: (%v)", err)
+		}
+	}
+	query := `update queue_jobs set status = $1, result = $2, error = $3, updated_at = $4 where id = $5`
+	if _, err := tx.Exec(ctx, query, status, resultJSON, errText, time.Now().UTC(), queue.ID); err != nil {
+		log.Errorf("MQ: error updating job status: (%v)", err)
+	}
+}
+
+// pruneJobs deletes finished status records older than result retention.
+func (c consume) pruneJobs(ctx context.Context) error {
+	query := `delete from queue_jobs where status in ($1, $2, $3) and updated_at < $4`
+	if _, err := c.pool.Exec(ctx, query, JobStatusSucceeded, JobStatusDead, JobStatusCanceled,
+		time.Now().UTC().Add(-c.resultRetention)); err != nil {
+		return fmt.Errorf("error pruning jobs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// commitTx is one consume tx whose commit fails while err is set.
+type commitTx struct {
+	pgx.Tx
+	err error
+}
+
+func (tx commitTx) Commit(ctx context.Context) error {
+	return tx.err
+}
+
+// recordExecer records statuses written by job status updates.
+type recordExecer struct {
+	statuses []JobStatus
+}
+
+func (e *recordExecer) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	e.statuses = append(e.statuses, arguments[0].(JobStatus))
+	return nil, nil
+}
+
+func TestConsumeCommitResetsJob(t *testing.T) {
+	cases := []struct {
+		name      string
+		tracked   bool
+		commitErr error
+		want      []JobStatus
+	}{
+		{name: "commit fails", tracked: true, commitErr: errors.New("connection reset"), want: []JobStatus{JobStatusRunning, JobStatusPending}},
+		{name: "commit succeeds", tracked: true, want: []JobStatus{JobStatusRunning}},
+		{name: "untracked commit fails", commitErr: errors.New("connection reset")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &recordExecer{}
+			c := consume{jobDB: db}
+			queue := Queue{ID: 12, Tracked: tc.tracked}
+
+			c.startJob(queue)
+			c.commit(context.Background(), commitTx{err: tc.commitErr}, queue)
+
+			if len(db.statuses) != len(tc.want) {
+				t.Fatalf("statuses = %v, want %v", db.statuses, tc.want)
+			}
+			for i := range tc.want {
+				if db.statuses[i] != tc.want[i] {
+					t.Fatalf("statuses = %v, want %v", db.statuses, tc.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package mq
+
+import (
+	"fmt"
+	"time"
+)
+
+// SendOption configures how one message is sent.
+type SendOption func(o *sendOptions)
+
+type sendOptions struct {
+	// messages with the same ordering key are consumed one by one in enqueue order, per consumer
+	orderingKey *string
+
+	// caller supplied key to cancel or reschedule pending messages later
+	scheduleKey *string
+
+	// duplicate messages with the same idempotency key are skipped, per consumer
+	idempotencyKey *string
+
+	// pending message with the same coalescing key is replaced, per consumer
+	coalesceKey *string
+
+	// absolute time to consume this message
+	scheduleAt *time.Time
+
+	// delay overriding consumer's delay for this message
+	delay *time.Duration
+
+	// only deliver this message to these consumers
+	consumerNames []string
+
+	// keep one status record for this message
+	tracked bool
+}
+
+func newSendOptions(opts []SendOption) sendOptions {
+	var o sendOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// checkAt returns when the consumer should consume this message sent at now.
+func (o sendOptions) checkAt(consumer Consumer, now time.Time) time.Time {
+	if o.scheduleAt != nil {
+		return o.scheduleAt.UTC()
+	}
+	if o.delay != nil {
+		return now.Add(*o.delay)
+	}
+	return now.Add(consumer.Delay())
+}
+
+// filterConsumers returns the event's consumers this message is delivered to.
+func (o sendOptions) filterConsumers(consumerGroups []Consumer) ([]Consumer, error) {
+	if len(o.consumerNames) == 0 {
+		return consumerGroups, nil
+	}
+	byName := make(map[string]Consumer, len(consumerGroups))
+	for _, consumer := range consumerGroups {
+		byName[consumer.Name()] = consumer
+	}
+	result := make([]Consumer, 0, len(o.consumerNames))
+	added := make(map[string]bool, len(o.consumerNames))
+	for _, name := range o.consumerNames {
+		consumer, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("mq consumer: %s does not subscribe to this event", name)
+		}
+		// one consumer given twice still gets one message
+		if added[name] {
+			continue
+		}
+		added[name] = true
+		result = append(result, consumer)
+	}
+	return result, nil
+}
+
+// WithOrderingKey sets the ordering key, such as order id, for this message.
+// For each consumer, messages with the same ordering key are consumed in send order and never in parallel,
+// while messages with different keys are still consumed concurrently.
+// One message blocks later messages with the same key until it is consumed successfully or becomes dead.
+// Sending takes one advisory lock on the key until the sending tx ends, so concurrent txs sending with the same
+// key wait for each other, and send order is the order these txs commit.
+func WithOrderingKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.orderingKey = &key
+	}
+}
+
+// WithScheduleKey sets one caller supplied key, such as `order:12:cancel`, for this message.
+// Pending messages can be canceled or rescheduled by this key later, see Provider.CancelMessagesByKey
+// and Provider.RescheduleMessagesByKey.
+func WithScheduleKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.scheduleKey = &key
+	}
+}
+
+// WithIdempotencyKey sets the idempotency key, such as http request id, for this message.
+// If one message with the same key has been sent to one consumer within the provider's dedup window,
+// this message is silently skipped for that consumer. See WithDedupWindow.
+func WithIdempotencyKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.idempotencyKey = &key
+	}
+}
+
+// WithCoalesceKey sets the coalescing key, such as `search:product:12`, for this message.
+// If one pending message with the same key exists for one consumer, that message takes this message's payload
+// and its check time is pushed out by the consumer's delay, instead of inserting another message.
+// Together with consumer's delay, this gives "process once after the last change" semantics.
+func WithCoalesceKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.coalesceKey = &key
+	}
+}
+
+// WithScheduleAt sets the absolute time to consume this message, such as 9:00 tomorrow.
+// It overrides both consumer's delay and WithDelay.
+func WithScheduleAt(at time.Time) SendOption {
+	return func(o *sendOptions) {
+		o.scheduleAt = &at
+	}
+}
+
+// WithDelay overrides consumer's delay for this message only.
+func WithDelay(delay time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.delay = &delay
+	}
+}
+
+// WithConsumers only delivers this message to given consumers, instead of all consumers subscribing to the event.
+// Sending fails if any given consumer doesn't subscribe to the event. Consumers given more than once get the message once.
+func WithConsumers(consumerNames ...string) SendOption {
+	return func(o *sendOptions) {
+		o.consumerNames = append(o.consumerNames, consumerNames...)
+	}
+}
+
+// WithJob keeps one status record for this message, i.e, pending, running, succeeded, failed, dead or canceled, together with
+// consumer's result or error. Query it by queue id with Provider.Job. Consumers may implement ResultConsumer
+// to save one result.
+func WithJob() SendOption {
+	return func(o *sendOptions) {
+		o.tracked = true
+	}
+}
@@ -0,0 +1,113 @@
+package mq
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSendOptionsCheckAt(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	at := time.Date(2024, 1, 3, 9, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60))
+	consumer := testConsumer{name: "notify:order:order_created", delay: 5 * time.Minute}
+
+	tests := []struct {
+		name string
+		opts []SendOption
+		want time.Time
+	}{
+		{
+			name: "consumer delay by default",
+			want: now.Add(5 * time.Minute),
+		},
+		{
+			name: "delay overrides consumer delay",
+			opts: []SendOption{WithDelay(time.Hour)},
+			want: now.Add(time.Hour),
+		},
+		{
+			name: "zero delay overrides consumer delay",
+			opts: []SendOption{WithDelay(0)},
+			want: now,
+		},
+		{
+			name: "schedule time in utc",
+			opts: []SendOption{WithScheduleAt(at)},
+			want: time.Date(2024, 1, 3, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "schedule time overrides delay",
+			opts: []SendOption{WithScheduleAt(at), WithDelay(time.Hour)},
+			want: time.Date(2024, 1, 3, 1, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSendOptions(tt.opts).checkAt(consumer, now)
+			if !got.Equal(tt.want) || got.Location() != time.UTC {
+				t.Errorf("checkAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendOptionsFilterConsumers(t *testing.T) {
+	group := []Consumer{
+		testConsumer{name: "notify:order:order_created"},
+		testConsumer{name: "order:order:order_created"},
+		testConsumer{name: "report:order:order_created"},
+	}
+
+	tests := []struct {
+		name    string
+		opts    []SendOption
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "all consumers by default",
+			want: []string{"notify:order:order_created", "order:order:order_created", "report:order:order_created"},
+		},
+		{
+			name: "only given consumers, in given order",
+			opts: []SendOption{WithConsumers("report:order:order_created", "notify:order:order_created")},
+			want: []string{"report:order:order_created", "notify:order:order_created"},
+		},
+		{
+			name: "repeated option adds consumers",
+			opts: []SendOption{WithConsumers("order:order:order_created"), WithConsumers("notify:order:order_created")},
+			want: []string{"order:order:order_created", "notify:order:order_created"},
+		},
+		{
+			name: "duplicate consumers only once",
+			opts: []SendOption{WithConsumers("report:order:order_created", "report:order:order_created"),
+				WithConsumers("notify:order:order_created", "report:order:order_created")},
+			want: []string{"report:order:order_created", "notify:order:order_created"},
+		},
+		{
+			name:    "consumer not subscribing to the event",
+			opts:    []SendOption{WithConsumers("notify:order:order_paid")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newSendOptions(tt.opts).filterConsumers(group)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filterConsumers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			names := make([]string, 0, len(got))
+			for _, consumer := range got {
+				names = append(names, consumer.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("filterConsumers() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
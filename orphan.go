@@ -0,0 +1,77 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/labstack/gommon/log"
+)
+
+// Orphan is one consumer name whose messages have been due longer than the grace period, while no consumer
+// with this name or alias is registered in current process, such as one consumer only existing in one newer version.
+type Orphan struct {
+	ConsumerName string `json:"consumer_name"`
+	// number of due messages
+	Messages int64 `json:"messages"`
+	// check time of the oldest due message
+	OldestDueAt time.Time `json:"oldest_due_at"`
+}
+
+// OrphanHook receives newly found orphans.
+type OrphanHook func(ctx context.Context, orphans []Orphan)
+
+// orphanReporter finds orphans, and reports each orphan once until its messages are gone.
+type orphanReporter struct {
+	grace time.Duration
+	hook  OrphanHook
+
+	// consumer names reported already. Only accessed by the housekeeping goroutine.
+	reported map[string]bool
+}
+
+func newOrphanReporter(grace time.Duration, hook OrphanHook) *orphanReporter {
+	if hook == nil {
+		hook = logOrphans
+	}
+	return &orphanReporter{
+		grace:    grace,
+		hook:     hook,
+		reported: make(map[string]bool),
+	}
+}
+
+func (r *orphanReporter) report(ctx context.Context, c consume) error {
+	orphans := []Orphan{}
+	query := `select consumer_name, count(*) as messages, min(check_at) as oldest_due_at from queues
+		where is_dead = false and check_at < $1 and not (consumer_name = any($2))
+		group by 1 order by 1`
+	if err := pgxscan.Select(ctx, c.pool, &orphans, query, time.Now().UTC().Add(-r.grace), registeredNames(c.registry)); err != nil {
+		return fmt.Errorf("error selecting orphans: %w", err)
+	}
+
+	found := make(map[string]bool, len(orphans))
+	var fresh []Orphan
+	for _, orphan := range orphans {
+		found[orphan.ConsumerName] = true
+		if !r.reported[orphan.ConsumerName] {
+			fresh = append(fresh, orphan)
+		}
+	}
+	// orphans whose messages are gone may be reported again later
+	r.reported = found
+
+	if len(fresh) > 0 {
+		r.hook(ctx, fresh)
+	}
+	return nil
+}
+
+func logOrphans(ctx context.Context, orphans []Orphan) {
+	names := make([]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		names = append(names, orphan.ConsumerName)
+	}
+	log.Warnf("MQ: messages of consumers %v are due, yet these consumers are not registered", names)
+}
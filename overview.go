@@ -0,0 +1,74 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/georgysavva/scany/pgxscan"
+)
+
+// ErrMessageNotFound is returned when no live or dead message exists for the queue id.
+var ErrMessageNotFound = errors.New("message not found")
+
+// Overview shows current queue health, such as in dashboards.
+type Overview struct {
+	// message statistics per consumer and per event
+	Stats *Stats `json:"stats"`
+	// the oldest due message. nil when no message is due.
+	OldestDue *Queue `json:"oldest_due"`
+	// number of live messages per retry times
+	RetryDistribution map[int]int64 `json:"retry_distribution"`
+}
+
+func (a admin) Overview(ctx context.Context) (*Overview, error) {
+	stats, err := a.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	overview := &Overview{
+		Stats:             stats,
+		RetryDistribution: make(map[int]int64),
+	}
+
+	retries := []struct {
+		Retry int
+		Count int64
+	}{}
+	query := `select retry, count(*) as count from queues where is_dead = false group by 1`
+	if err := pgxscan.Select(ctx, a.pool, &retries, query); err != nil {
+		return nil, fmt.Errorf("error selecting retry distribution: %w", err)
+	}
+	for _, retry := range retries {
+		overview.RetryDistribution[retry.Retry] = retry.Count
+	}
+
+	oldest := []Queue{}
+	query = `select * from queues where is_dead = false and check_at < $1 order by check_at, id limit 1`
+	if err := pgxscan.Select(ctx, a.pool, &oldest, query, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("error selecting oldest due message: %w", err)
+	}
+	if len(oldest) > 0 {
+		overview.OldestDue = &oldest[0]
+	}
+	return overview, nil
+}
+
+func (a admin) Message(ctx context.Context, id int64) (*Queue, error) {
+	queues := []Queue{}
+	query := `select id, consumer_name, message, retry, is_dead, failed_reason, check_at, created_at,
+			ordering_key, schedule_key, coalesce_key, tracked
+		from queues where id = $1
+		union all
+		select id, consumer_name, message, retry, true, failed_reason, check_at, created_at,
+			ordering_key, schedule_key, coalesce_key, tracked
+		from queue_dead_letters where id = $1`
+	if err := pgxscan.Select(ctx, a.pool, &queues, query, id); err != nil {
+		return nil, fmt.Errorf("error selecting message: %w", err)
+	}
+	if len(queues) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return &queues[0], nil
+}
@@ -0,0 +1,39 @@
+package mq
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// testTx begins one tx on the postgres database at env MQ_TEST_DSN, migrated with Migrate, and rolls it back
+// when the test ends. Tests needing postgres are skipped without MQ_TEST_DSN.
+func testTx(t *testing.T) pgx.Tx {
+	t.Helper()
+	dsn := os.Getenv("MQ_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MQ_TEST_DSN is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("error connecting postgres: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if err := Migrate(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatalf("error starting tx: %v", err)
+	}
+	t.Cleanup(func() {
+		tx.Rollback(ctx)
+	})
+	return tx
+}
@@ -2,6 +2,7 @@ package mq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -10,74 +11,251 @@ import (
 )
 
 type Provider interface {
-	// send message with pgx tx
-	SendMessage(ctx context.Context, tx pgx.Tx, message Message) error
+	// send message with pgx tx. It returns the generated queue id for each consumer of this message.
+	SendMessage(ctx context.Context, tx pgx.Tx, message Message, opts ...SendOption) (MessageIDs, error)
+
+	// send messages in bulk with pgx tx, such as backfills. It returns the generated queue ids of each message.
+	// Send options apply to every message, i.e, with coalescing key, each consumer only gets the last message.
+	// Idempotency key is rejected with ErrBulkIdempotencyKey, since one key can't tell which message of the
+	// batch is one duplicate. Send messages which need idempotency keys with SendMessage one by one.
+	SendMessages(ctx context.Context, tx pgx.Tx, messages []Message, opts ...SendOption) ([]MessageIDs, error)
+
+	// send message within its own tx. Prefer SendMessage with the tx which changes business data, so the message
+	// is only sent when business data is committed. Publish fits fire-and-forget producers like cron jobs.
+	Publish(ctx context.Context, message Message, opts ...SendOption) (MessageIDs, error)
+
+	// cancel one pending message by queue id, with pgx tx
+	CancelMessage(ctx context.Context, tx pgx.Tx, id int64) (int64, error)
+
+	// cancel pending messages sent with the schedule key, with pgx tx. Optionally only for given consumers.
+	CancelMessagesByKey(ctx context.Context, tx pgx.Tx, key string, consumerNames ...string) (int64, error)
+
+	// reschedule one pending message by queue id to be consumed at given time, with pgx tx
+	RescheduleMessage(ctx context.Context, tx pgx.Tx, id int64, at time.Time) (int64, error)
+
+	// reschedule pending messages sent with the schedule key, with pgx tx. Optionally only for given consumers.
+	RescheduleMessagesByKey(ctx context.Context, tx pgx.Tx, key string, at time.Time, consumerNames ...string) (int64, error)
+
+	// get status record of one message sent with WithJob, by queue id
+	Job(ctx context.Context, id int64) (*Job, error)
+
+	// delete expired idempotency keys
+	PruneDedupKeys(ctx context.Context) (int64, error)
 }
 
-func NewProvider(pool *pgxpool.Pool) Provider {
-	return provider{
-		pool: pool,
+// ErrBulkIdempotencyKey is returned when SendMessages is called with WithIdempotencyKey.
+var ErrBulkIdempotencyKey = errors.New("idempotency key is not supported by SendMessages")
+
+// MessageIDs holds the generated queue ids of one message, keyed by consumer name.
+type MessageIDs map[string]int64
+
+// DefaultDedupWindow is how long one idempotency key blocks duplicate messages by default.
+const DefaultDedupWindow = 24 * time.Hour
+
+// ProviderOption configures the provider created by NewProvider.
+type ProviderOption interface {
+	applyProvider(p *provider)
+}
+
+type providerOptionFunc func(p *provider)
+
+func (f providerOptionFunc) applyProvider(p *provider) {
+	f(p)
+}
+
+// WithDedupWindow sets how long one idempotency key blocks duplicate messages. After this window,
+// the same key may be used again.
+func WithDedupWindow(window time.Duration) ProviderOption {
+	return providerOptionFunc(func(p *provider) {
+		p.dedupWindow = window
+	})
+}
+
+func NewProvider(pool *pgxpool.Pool, opts ...ProviderOption) Provider {
+	p := provider{
+		pool:        pool,
+		registry:    defaultRegistry,
+		dedupWindow: DefaultDedupWindow,
 	}
+	for _, opt := range opts {
+		opt.applyProvider(&p)
+	}
+	return p
 }
 
 type provider struct {
-	consumers map[Event][]Consumer
-	pool      *pgxpool.Pool
+	pool        *pgxpool.Pool
+	registry    Registry
+	dedupWindow time.Duration
 }
 
-// Lazy loading. innner message group
-// For one event, such as `order_created`, different services (such as notify, order) may subscribe to this event.
-// When this event `order_created` happens, we will create two messages. One message is for service notify's consumer,
-// while the other one is for service order's consumer.
-// In above case, event `order_created` will have a group of consumers: notify consumer and order consumer.
-// So here we have a map data structure `map[Event][]Consumer`,  key is the event, and value is a slice of consumers
-// subscribing to this event.
-func (p provider) innerConsumers() map[Event][]Consumer {
-	if p.consumers != nil {
-		return p.consumers
-	}
-
-	innerConsumers := make(map[Event][]Consumer)
-	for _, consumer := range consumers {
-		if _, ok := innerConsumers[consumer.Event()]; !ok {
-			innerConsumers[consumer.Event()] = []Consumer{consumer}
-		} else {
-			innerConsumers[consumer.Event()] = append(innerConsumers[consumer.Event()], consumer)
-		}
+func (p provider) SendMessage(ctx context.Context, tx pgx.Tx, message Message, opts ...SendOption) (MessageIDs, error) {
+	ids, err := p.sendMessages(ctx, tx, []Message{message}, newSendOptions(opts))
+	if err != nil {
+		return nil, err
 	}
-	p.consumers = innerConsumers
-	return p.consumers
+	return ids[0], nil
 }
 
-func (p provider) SendMessage(ctx context.Context, tx pgx.Tx, message Message) error {
-	event := message.Event()
-	consumerGroups, ok := p.innerConsumers()[event]
-	if !ok {
-		return fmt.Errorf("mq event: %s does not have consumer groups", event.String())
+func (p provider) SendMessages(ctx context.Context, tx pgx.Tx, messages []Message, opts ...SendOption) ([]MessageIDs, error) {
+	o := newSendOptions(opts)
+	if o.idempotencyKey != nil {
+		return nil, ErrBulkIdempotencyKey
 	}
-	query := `insert into queues(consumer_name, message, check_at) values`
+	return p.sendMessages(ctx, tx, messages, o)
+}
 
-	var (
-		index int
-		args  []interface{}
-	)
+// sendMessages fans out each message to its event's consumers, and inserts all rows into table `queues`.
+func (p provider) sendMessages(ctx context.Context, tx pgx.Tx, messages []Message, o sendOptions) ([]MessageIDs, error) {
 	createdAt := time.Now().UTC()
 
-	for i, consumer := range consumerGroups {
-		val := fmt.Sprintf("($%d, $%d, $%d)", index+1, index+2, index+3)
-		index = index + 3
-		if i < len(consumerGroups)-1 {
-			query = query + val + `,`
-		} else {
-			query = query + val
+	groups, allConsumers, err := p.resolveConsumers(messages, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// skip consumers which have received this message already. Only SendMessage sends with idempotency key,
+	// so the key is for one single message.
+	if o.idempotencyKey != nil && len(allConsumers) > 0 {
+		fresh, err := p.dedupConsumers(ctx, tx, allConsumers, *o.idempotencyKey, createdAt)
+		if err != nil {
+			return nil, err
+		}
+		freshNames := make(map[string]bool, len(fresh))
+		for _, consumer := range fresh {
+			freshNames[consumer.Name()] = true
+		}
+		for i, consumerGroups := range groups {
+			filtered := make([]Consumer, 0, len(consumerGroups))
+			for _, consumer := range consumerGroups {
+				if freshNames[consumer.Name()] {
+					filtered = append(filtered, consumer)
+				}
+			}
+			groups[i] = filtered
+		}
+	}
+
+	rows, rowIndexes := buildRows(messages, groups, o, createdAt)
+
+	if o.orderingKey != nil && len(rows) > 0 {
+		if err := lockOrderingKey(ctx, tx, *o.orderingKey); err != nil {
+			return nil, err
+		}
+	}
+	ids, err := insertRows(ctx, tx, rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := insertJobs(ctx, tx, rows, ids); err != nil {
+		return nil, err
+	}
+
+	result := make([]MessageIDs, len(messages))
+	for i := range messages {
+		result[i] = make(MessageIDs, len(rowIndexes[i]))
+		for name, index := range rowIndexes[i] {
+			result[i][name] = ids[index]
 		}
-		checkAt := createdAt.Add(consumer.Delay())
-		args = append(args, consumer.Name(), message, checkAt)
 	}
-	if len(args) > 0 {
-		if _, err := tx.Exec(ctx, query, args...); err != nil {
-			return fmt.Errorf("error inserting message queue: %w", err)
+	return result, nil
+}
+
+// resolveConsumers returns consumers of each message, and all these consumers once each. Each event is resolved
+// once, so messages of the same event get the same consumers, even if consumers are registered meanwhile.
+func (p provider) resolveConsumers(messages []Message, o sendOptions) ([][]Consumer, []Consumer, error) {
+	groups := make([][]Consumer, len(messages))
+	var allConsumers []Consumer
+	seen := make(map[string]bool)
+	resolved := make(map[Event][]Consumer)
+	for i, message := range messages {
+		event := message.Event()
+		if consumerGroups, ok := resolved[event]; ok {
+			groups[i] = consumerGroups
+			continue
+		}
+		consumerGroups := p.registry.ConsumersOf(event)
+		if len(consumerGroups) == 0 {
+			return nil, nil, fmt.Errorf("mq event: %s does not have consumer groups", event.String())
+		}
+		consumerGroups, err := o.filterConsumers(consumerGroups)
+		if err != nil {
+			return nil, nil, err
+		}
+		resolved[event] = consumerGroups
+		groups[i] = consumerGroups
+		for _, consumer := range consumerGroups {
+			if !seen[consumer.Name()] {
+				seen[consumer.Name()] = true
+				allConsumers = append(allConsumers, consumer)
+			}
 		}
 	}
+	return groups, allConsumers, nil
+}
+
+// buildRows builds rows to insert for each message's consumers, and the row index of each message's consumer.
+// With coalescing key, one consumer only gets one row, which holds the last message.
+func buildRows(messages []Message, groups [][]Consumer, o sendOptions, createdAt time.Time) ([]queueRow, []map[string]int) {
+	var size int
+	for _, group := range groups {
+		size += len(group)
+	}
+	rows := make([]queueRow, 0, size)
+	rowIndexes := make([]map[string]int, len(messages))
+	coalesced := make(map[string]int)
+	for i, message := range messages {
+		rowIndexes[i] = make(map[string]int, len(groups[i]))
+		for _, consumer := range groups[i] {
+			row := queueRow{
+				consumerName: consumer.Name(),
+				message:      message,
+				checkAt:      o.checkAt(consumer, createdAt),
+				orderingKey:  o.orderingKey,
+				scheduleKey:  o.scheduleKey,
+				coalesceKey:  o.coalesceKey,
+				tracked:      o.tracked,
+			}
+			if o.coalesceKey != nil {
+				if index, ok := coalesced[consumer.Name()]; ok {
+					rows[index] = row
+					rowIndexes[i][consumer.Name()] = index
+					continue
+				}
+				coalesced[consumer.Name()] = len(rows)
+			}
+			rowIndexes[i][consumer.Name()] = len(rows)
+			rows = append(rows, row)
+		}
+	}
+	return rows, rowIndexes
+}
+
+// lockOrderingKey takes one advisory lock on the ordering key until tx ends. Messages are consumed in queue id order,
+// while queue ids are allocated when inserted, not when committed. Without this lock, two concurrent txs sending with
+// the same key could commit in the other order of their queue ids. With it, the later tx waits for the earlier one,
+// and gets its queue ids after the earlier one commits.
+func lockOrderingKey(ctx context.Context, tx pgx.Tx, key string) error {
+	if _, err := tx.Exec(ctx, `select pg_advisory_xact_lock(hashtext('mq:ordering_key'), hashtext($1))`, key); err != nil {
+		return fmt.Errorf("error locking ordering key: %w", err)
+	}
 	return nil
 }
+
+func (p provider) Publish(ctx context.Context, message Message, opts ...SendOption) (MessageIDs, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting tx at publish: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := p.SendMessage(ctx, tx, message, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing tx at publish: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,117 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendMessagesIdempotencyKey(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(testConsumer{name: "notify:order:order_created", event: EventOrderCreated})
+	p := NewProvider(nil, WithRegistry(registry))
+
+	messages := []Message{NewOrderMessage(EventOrderCreated), NewOrderMessage(EventOrderCreated)}
+	if _, err := p.SendMessages(context.Background(), nil, messages, WithIdempotencyKey("request_12")); !errors.Is(err, ErrBulkIdempotencyKey) {
+		t.Fatalf("SendMessages() error = %v, want %v", err, ErrBulkIdempotencyKey)
+	}
+}
+
+// TestResolveConsumersWhileRegistering checks, with -race, that sending resolves consumers and builds rows from one
+// consistent registry view, while one consumer of the event keeps registering and unregistering.
+func TestResolveConsumersWhileRegistering(t *testing.T) {
+	registry := newTestRegistry(300)
+	p := NewProvider(nil, WithRegistry(registry)).(provider)
+	messages := make([]Message, 10)
+	for i := range messages {
+		messages[i] = NewOrderMessage("event_0")
+	}
+
+	var (
+		wg   sync.WaitGroup
+		done atomic.Bool
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			o := newSendOptions(nil)
+			if i%2 == 1 {
+				o = newSendOptions([]SendOption{WithConsumers("consumer_2", "consumer_0")})
+			}
+			for !done.Load() {
+				groups, _, err := p.resolveConsumers(messages, o)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				rows, _ := buildRows(messages, groups, o, time.Now().UTC())
+				var want int
+				for _, group := range groups {
+					if n := len(group); n < 2 || n > 4 || (len(o.consumerNames) > 0 && n != 2) {
+						t.Errorf("message got %d consumers", n)
+						return
+					}
+					want += len(group)
+				}
+				if len(rows) != want {
+					t.Errorf("buildRows() built %d rows, want %d", len(rows), want)
+					return
+				}
+			}
+		}(i)
+	}
+	extra := testConsumer{name: "consumer_extra", event: "event_0"}
+	for i := 0; i < 1000; i++ {
+		registry.Register(extra)
+		registry.Unregister(extra.name)
+	}
+	done.Store(true)
+	wg.Wait()
+}
+
+// BenchmarkResolveConsumers resolves consumers and builds rows of M messages for one event with N consumers,
+// i.e, everything SendMessages does before talking to postgres.
+func BenchmarkResolveConsumers(b *testing.B) {
+	for _, n := range []int{3, 30, 300} {
+		for _, m := range []int{1, 100, 1000} {
+			registry := NewRegistry()
+			names := make([]string, n)
+			for i := range names {
+				names[i] = fmt.Sprintf("consumer_%d", i)
+				registry.Register(testConsumer{name: names[i], event: EventOrderCreated})
+			}
+			p := NewProvider(nil, WithRegistry(registry)).(provider)
+			messages := make([]Message, m)
+			for i := range messages {
+				messages[i] = NewOrderMessage(EventOrderCreated).WithOrderID(int64(i))
+			}
+
+			for _, tc := range []struct {
+				name string
+				opts []SendOption
+			}{
+				{name: "all"},
+				{name: "selected", opts: []SendOption{WithConsumers(names[:n/3+1]...)}},
+			} {
+				b.Run(fmt.Sprintf("consumers=%d/messages=%d/%s", n, m, tc.name), func(b *testing.B) {
+					o := newSendOptions(tc.opts)
+					now := time.Now().UTC()
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						groups, _, err := p.resolveConsumers(messages, o)
+						if err != nil {
+							b.Fatal(err)
+						}
+						buildRows(messages, groups, o, now)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds registered consumers. Providers fan out messages to consumers of one registry, and consume
+// processes claim messages of consumers of one registry. Separate registries allow multiple queues in one process,
+// or tests running in parallel. See WithRegistry. Package-level RegisterConsumer uses the default registry.
+type Registry interface {
+	// register one consumer. It panics when the consumer's name or aliases are registered already.
+	Register(consumer Consumer)
+
+	// remove one consumer by name, together with its aliases. It returns whether the consumer was registered.
+	Unregister(name string) bool
+
+	// get one registered consumer by its name or one of its aliases
+	Consumer(name string) (Consumer, bool)
+
+	// get all registered consumers, sorted by name
+	Consumers() []Consumer
+
+	// get consumers subscribing to the event, sorted by name. The returned slice is shared, and must not be modified.
+	ConsumersOf(event Event) []Consumer
+}
+
+// defaultRegistry is used without WithRegistry.
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry returns the registry used by RegisterConsumer, and by NewProvider, NeWConsume and NewAdmin
+// without WithRegistry.
+func DefaultRegistry() Registry {
+	return defaultRegistry
+}
+
+func NewRegistry() Registry {
+	return &registry{
+		consumers: make(map[string]Consumer),
+		aliases:   make(map[string]string),
+	}
+}
+
+type registry struct {
+	mu sync.RWMutex
+	// registered consumers keyed by name
+	consumers map[string]Consumer
+	// registered consumer name of each alias
+	aliases map[string]string
+
+	// Lazy loading fan-out index, i.e, consumers of each event.
+	// For one event, such as `order_created`, different services (such as notify, order) may subscribe to this event.
+	// When this event happens, one message is created for each of these consumers. The index is built on first use,
+	// so sending messages doesn't scan all consumers, and dropped when consumers register or unregister.
+	index atomic.Pointer[map[Event][]Consumer]
+}
+
+func (r *registry) Register(consumer Consumer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := consumer.Name()
+	if _, dup := r.consumers[name]; dup {
+		panic("consumer register called twice for " + name)
+	}
+	if registered, dup := r.aliases[name]; dup {
+		panic("consumer name " + name + " is registered as alias of " + registered)
+	}
+	aliases := consumerAliasNames(consumer)
+	for _, alias := range aliases {
+		if _, dup := r.consumers[alias]; dup || alias == name {
+			panic("consumer alias " + alias + " is registered as consumer name")
+		}
+		if registered, dup := r.aliases[alias]; dup {
+			panic("consumer alias " + alias + " is registered as alias of " + registered)
+		}
+	}
+	r.consumers[name] = consumer
+	for _, alias := range aliases {
+		r.aliases[alias] = name
+	}
+	r.index.Store(nil)
+}
+
+func (r *registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.consumers[name]; !ok {
+		return false
+	}
+	delete(r.consumers, name)
+	for alias, registered := range r.aliases {
+		if registered == name {
+			delete(r.aliases, alias)
+		}
+	}
+	r.index.Store(nil)
+	return true
+}
+
+func (r *registry) Consumer(name string) (Consumer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if consumer, ok := r.consumers[name]; ok {
+		return consumer, true
+	}
+	if registered, ok := r.aliases[name]; ok {
+		return r.consumers[registered], true
+	}
+	return nil, false
+}
+
+func (r *registry) Consumers() []Consumer {
+	r.mu.RLock()
+	result := make([]Consumer, 0, len(r.consumers))
+	for _, consumer := range r.consumers {
+		result = append(result, consumer)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result
+}
+
+func (r *registry) ConsumersOf(event Event) []Consumer {
+	if index := r.index.Load(); index != nil {
+		return (*index)[event]
+	}
+
+	// Build the index with read lock held, so one concurrent Register or Unregister waits until the index is stored,
+	// then drops it. Concurrent builders store the same index.
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	index := make(map[Event][]Consumer)
+	for _, consumer := range r.consumers {
+		index[consumer.Event()] = append(index[consumer.Event()], consumer)
+	}
+	for _, group := range index {
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].Name() < group[j].Name()
+		})
+	}
+	r.index.Store(&index)
+	return index[event]
+}
+
+// RegistryOption is accepted by NewProvider, NeWConsume and NewAdmin.
+type RegistryOption interface {
+	ProviderOption
+	ConsumeOption
+	AdminOption
+}
+
+// WithRegistry uses the registry instead of the default registry.
+func WithRegistry(registry Registry) RegistryOption {
+	return registryOption{registry: registry}
+}
+
+type registryOption struct {
+	registry Registry
+}
+
+func (o registryOption) applyProvider(p *provider) {
+	p.registry = o.registry
+}
+
+func (o registryOption) applyConsume(c *consume) {
+	c.registry = o.registry
+}
+
+func (o registryOption) applyAdmin(a *admin) {
+	a.registry = o.registry
+}
+
+// registeredNames returns names and aliases of all consumers of the registry.
+func registeredNames(registry Registry) []string {
+	names := []string{}
+	for _, consumer := range registry.Consumers() {
+		names = append(names, claimNames(consumer)...)
+	}
+	return names
+}
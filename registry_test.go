@@ -0,0 +1,124 @@
+package mq
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestRegistry registers n consumers, 3 consumers per event, i.e, event_0 has consumers 0, 1 and 2.
+func newTestRegistry(n int) Registry {
+	registry := NewRegistry()
+	for i := 0; i < n; i++ {
+		registry.Register(testConsumer{name: fmt.Sprintf("consumer_%d", i), event: Event(fmt.Sprintf("event_%d", i/3))})
+	}
+	return registry
+}
+
+func TestRegistryConsumersOf(t *testing.T) {
+	registry := newTestRegistry(6)
+	if got := len(registry.ConsumersOf("event_0")); got != 3 {
+		t.Fatalf("ConsumersOf(event_0) has %d consumers, want 3", got)
+	}
+	if got := registry.ConsumersOf("event_none"); len(got) != 0 {
+		t.Fatalf("ConsumersOf(event_none) = %v, want none", got)
+	}
+
+	// the index is rebuilt after consumers register or unregister
+	registry.Register(testConsumer{name: "consumer_a", event: "event_0", aliases: []string{"consumer_old"}})
+	got := registry.ConsumersOf("event_0")
+	if len(got) != 4 || got[3].Name() != "consumer_a" {
+		t.Fatalf("ConsumersOf(event_0) = %v after register, want consumer_a last", got)
+	}
+	if !registry.Unregister("consumer_a") {
+		t.Fatal("Unregister(consumer_a) = false, want true")
+	}
+	if got := len(registry.ConsumersOf("event_0")); got != 3 {
+		t.Fatalf("ConsumersOf(event_0) has %d consumers after unregister, want 3", got)
+	}
+	if _, ok := registry.Consumer("consumer_old"); ok {
+		t.Fatal("alias consumer_old is still registered after unregister")
+	}
+}
+
+// TestRegistryConcurrent checks, with -race, that readers always see one index consistent with registered consumers.
+func TestRegistryConcurrent(t *testing.T) {
+	registry := newTestRegistry(300)
+	var (
+		wg   sync.WaitGroup
+		done atomic.Bool
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				if got := len(registry.ConsumersOf("event_0")); got != 3 && got != 4 {
+					t.Errorf("ConsumersOf(event_0) has %d consumers, want 3 or 4", got)
+					return
+				}
+			}
+		}()
+	}
+	extra := testConsumer{name: "consumer_extra", event: "event_0"}
+	for i := 0; i < 1000; i++ {
+		registry.Register(extra)
+		registry.Unregister(extra.name)
+	}
+	done.Store(true)
+	wg.Wait()
+
+	if got := len(registry.ConsumersOf("event_0")); got != 3 {
+		t.Errorf("ConsumersOf(event_0) has %d consumers, want 3", got)
+	}
+}
+
+// BenchmarkRegistryConsumersOf shows fan-out lookups on the send path don't grow with the number of consumers.
+func BenchmarkRegistryConsumersOf(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("consumers=%d", n), func(b *testing.B) {
+			registry := newTestRegistry(n)
+			registry.ConsumersOf("event_0")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(registry.ConsumersOf("event_0")) != 3 {
+					b.Fatal("want 3 consumers of event_0")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRegistryConsumersOfWhileRegistering looks up consumers in parallel, while one goroutine keeps registering
+// and unregistering one consumer. Run it with -race.
+func BenchmarkRegistryConsumersOfWhileRegistering(b *testing.B) {
+	registry := newTestRegistry(1000)
+	var (
+		wg   sync.WaitGroup
+		done atomic.Bool
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		extra := testConsumer{name: "consumer_extra", event: "event_0"}
+		for !done.Load() {
+			registry.Register(extra)
+			registry.Unregister(extra.name)
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if got := len(registry.ConsumersOf("event_0")); got != 3 && got != 4 {
+				b.Errorf("ConsumersOf(event_0) has %d consumers, want 3 or 4", got)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	done.Store(true)
+	wg.Wait()
+}
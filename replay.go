@@ -0,0 +1,213 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// replayBatchSize is the max number of messages enqueued in one tx when replaying.
+const replayBatchSize = 500
+
+// ReplayRequest replays archived events to one consumer, such as one new consumer which only sees future messages.
+//
+// One event is archived once for each consumer which has consumed it. These copies have the same payload and the same
+// created time, since one event's messages are sent within one tx. So without SourceConsumerName, archived messages
+// with the same payload and created time are replayed once. Different events with the same payload sent within the same
+// tx are replayed once too, set SourceConsumerName to replay every archived message of one consumer instead.
+type ReplayRequest struct {
+	// consumer to re-enqueue events for. It doesn't need to be registered if Event is set.
+	ConsumerName string
+	// event to replay. Default is the registered consumer's event.
+	Event Event
+	// only replay archived messages of this consumer, each one of them. Default replays archived messages of all
+	// consumers, each distinct payload and created time once.
+	SourceConsumerName string
+	// only replay events created at or after this time
+	From time.Time
+	// only replay events created before this time
+	To time.Time
+	// max messages enqueued per second. Zero means unlimited.
+	Rate int
+	// only count messages to be replayed, without enqueuing them
+	DryRun bool
+}
+
+// replayEvent returns the event to replay for the request.
+func (r ReplayRequest) replayEvent(registry Registry) (Event, error) {
+	if r.ConsumerName == "" {
+		return "", errors.New("replay consumer name is required")
+	}
+	if r.Event != "" {
+		return r.Event, nil
+	}
+	consumer, ok := registry.Consumer(r.ConsumerName)
+	if !ok {
+		return "", fmt.Errorf("replay consumer %s is not registered, set the event to replay", r.ConsumerName)
+	}
+	return consumer.Event(), nil
+}
+
+func (a admin) Replay(ctx context.Context, req ReplayRequest) (int64, error) {
+	event, err := req.replayEvent(a.registry)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		conds = `a.event = $1`
+		args  = []interface{}{event.String()}
+	)
+	if req.SourceConsumerName != "" {
+		args = append(args, req.SourceConsumerName)
+		conds += fmt.Sprintf(` and a.consumer_name = $%d`, len(args))
+	} else {
+		// only the first archived copy of each event
+		conds += ` and not exists (
+			select 1 from queue_archives dup where dup.event = a.event and dup.created_at = a.created_at
+			and dup.message = a.message and dup.id < a.id
+		)`
+	}
+	if !req.From.IsZero() {
+		args = append(args, req.From.UTC())
+		conds += fmt.Sprintf(` and a.created_at >= $%d`, len(args))
+	}
+	if !req.To.IsZero() {
+		args = append(args, req.To.UTC())
+		conds += fmt.Sprintf(` and a.created_at < $%d`, len(args))
+	}
+
+	if req.DryRun {
+		var count int64
+		if err := a.pool.QueryRow(ctx, `select count(*) from queue_archives a where `+conds, args...).Scan(&count); err != nil {
+			return 0, fmt.Errorf("error counting archived messages: %w", err)
+		}
+		return count, nil
+	}
+
+	// page through archived messages in their original order by (created_at, id). Each page continues the index scan
+	// after the last page, instead of selecting the whole archive again.
+	var (
+		total     int64
+		afterAt   time.Time
+		afterID   int64
+		batchSize = replayBatchSize
+	)
+	if req.Rate > 0 && req.Rate < batchSize {
+		batchSize = req.Rate
+	}
+	pageQuery := fmt.Sprintf(`select a.id, a.message, a.created_at from queue_archives a
+		where %s and (a.created_at, a.id) > ($%d, $%d) order by a.created_at, a.id limit $%d`,
+		conds, len(args)+1, len(args)+2, len(args)+3)
+
+	for {
+		started := time.Now()
+		rows, err := a.pool.Query(ctx, pageQuery, append(args, afterAt, afterID, batchSize)...)
+		if err != nil {
+			return total, fmt.Errorf("error selecting archived messages: %w", err)
+		}
+		var messages []Message
+		for rows.Next() {
+			msg := new(MQMessage)
+			if err := rows.Scan(&afterID, msg, &afterAt); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("error scanning archived messages: %w", err)
+			}
+			messages = append(messages, msg)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, fmt.Errorf("error selecting archived messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return total, nil
+		}
+
+		count, err := a.enqueueBatch(ctx, req.ConsumerName, messages)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if len(messages) < batchSize {
+			return total, nil
+		}
+		if err := waitRate(ctx, started, len(messages), req.Rate); err != nil {
+			return total, err
+		}
+	}
+}
+
+func (a admin) ReplayMessages(ctx context.Context, consumerName string, messages []Message, rate int) (int64, error) {
+	if consumerName == "" {
+		return 0, errors.New("replay consumer name is required")
+	}
+	batchSize := replayBatchSize
+	if rate > 0 && rate < batchSize {
+		batchSize = rate
+	}
+
+	var total int64
+	for start := 0; start < len(messages); start += batchSize {
+		started := time.Now()
+		end := start + batchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		count, err := a.enqueueBatch(ctx, consumerName, messages[start:end])
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if end < len(messages) {
+			if err := waitRate(ctx, started, end-start, rate); err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
+
+// enqueueBatch inserts messages for one consumer within one tx, to be consumed right now.
+func (a admin) enqueueBatch(ctx context.Context, consumerName string, messages []Message) (int64, error) {
+	now := time.Now().UTC()
+	rows := make([]queueRow, 0, len(messages))
+	for _, message := range messages {
+		rows = append(rows, queueRow{
+			consumerName: consumerName,
+			message:      message,
+			checkAt:      now,
+		})
+	}
+
+	tx, err := a.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting tx at replay: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := insertRows(ctx, tx, rows); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing tx at replay: %w", err)
+	}
+	return int64(len(rows)), nil
+}
+
+// waitRate sleeps long enough, so count messages enqueued since started keep within rate per second.
+func waitRate(ctx context.Context, started time.Time, count int, rate int) error {
+	if rate <= 0 {
+		return nil
+	}
+	wait := time.Duration(count)*time.Second/time.Duration(rate) - time.Since(started)
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Pending messages are messages not dead yet, including messages waiting for retry.
+// If one message is being consumed right now, below queries wait for the consume to finish, since that message is locked.
+
+func (p provider) CancelMessage(ctx context.Context, tx pgx.Tx, id int64) (int64, error) {
+	count, err := cancelMessages(ctx, tx, `id = $1`, []interface{}{id})
+	if err != nil {
+		return 0, fmt.Errorf("error canceling message: %w", err)
+	}
+	return count, nil
+}
+
+func (p provider) CancelMessagesByKey(ctx context.Context, tx pgx.Tx, key string, consumerNames ...string) (int64, error) {
+	where := `schedule_key = $1`
+	args := []interface{}{key}
+	if len(consumerNames) > 0 {
+		where += ` and consumer_name = any($2)`
+		args = append(args, consumerNames)
+	}
+	count, err := cancelMessages(ctx, tx, where, args)
+	if err != nil {
+		return 0, fmt.Errorf("error canceling messages by key: %w", err)
+	}
+	return count, nil
+}
+
+// cancelMessages deletes pending messages matching the where clause, and marks status records of tracked ones
+// canceled in the same statement.
+func cancelMessages(ctx context.Context, tx pgx.Tx, where string, args []interface{}) (int64, error) {
+	args = append(args, JobStatusCanceled, time.Now().UTC())
+	query := fmt.Sprintf(`with canceled as (
+			delete from queues where is_dead = false and %s returning id, tracked
+		), jobs as (
+			update queue_jobs set status = $%d, updated_at = $%d where id in (select id from canceled where tracked)
+		)
+		select count(*) from canceled`, where, len(args)-1, len(args))
+
+	var count int64
+	if err := tx.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (p provider) RescheduleMessage(ctx context.Context, tx pgx.Tx, id int64, at time.Time) (int64, error) {
+	query := `update queues set check_at = $1 where id = $2 and is_dead = false`
+	tag, err := tx.Exec(ctx, query, at.UTC(), id)
+	if err != nil {
+		return 0, fmt.Errorf("error rescheduling message: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (p provider) RescheduleMessagesByKey(ctx context.Context, tx pgx.Tx, key string, at time.Time, consumerNames ...string) (int64, error) {
+	query := `update queues set check_at = $1 where schedule_key = $2 and is_dead = false`
+	args := []interface{}{at.UTC(), key}
+	if len(consumerNames) > 0 {
+		query += ` and consumer_name = any($3)`
+		args = append(args, consumerNames)
+	}
+	tag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("error rescheduling messages by key: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
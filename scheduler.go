@@ -0,0 +1,111 @@
+package mq
+
+import "sync"
+
+// WeightedConsumer is one optional interface a Consumer may implement to get a bigger share of
+// consume capacity. Consumers without it, or returning a weight less than 1, have weight 1.
+type WeightedConsumer interface {
+	Weight() int
+}
+
+// scheduler hands out consumer names to workers in weighted round-robin order.
+// Each consumer gets its own turn to claim a message, so one consumer with a huge backlog
+// can not starve the other consumers.
+type scheduler struct {
+	registry Registry
+	// whether one registered consumer is scheduled
+	selected func(consumer Consumer) bool
+
+	mu    sync.Mutex
+	round []string
+	pos   int
+}
+
+func newScheduler(registry Registry, selected func(consumer Consumer) bool) *scheduler {
+	return &scheduler{
+		registry: registry,
+		selected: selected,
+	}
+}
+
+// next returns the consumer name whose message should be claimed next.
+// An empty name means no consumer is registered, or selected.
+func (s *scheduler) next() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pos >= len(s.round) {
+		// start one new round. It's rebuilt every round, so consumers registered later are scheduled too.
+		s.round = buildRound(s.registry, s.selected)
+		s.pos = 0
+	}
+	if len(s.round) == 0 {
+		return ""
+	}
+	name := s.round[s.pos]
+	s.pos++
+	return name
+}
+
+// size returns the number of turns in one round.
+func (s *scheduler) size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.round) == 0 {
+		return 1
+	}
+	return len(s.round)
+}
+
+// claimNames returns names and aliases of all registered and selected consumers, i.e, every consumer name
+// whose messages one round may claim.
+func (s *scheduler) claimNames() []string {
+	var names []string
+	for _, consumer := range s.registry.Consumers() {
+		if s.selected(consumer) {
+			names = append(names, claimNames(consumer)...)
+		}
+	}
+	return names
+}
+
+// buildRound creates one round of consumer names with smooth weighted round-robin, i.e,
+// weights a:3, b:1 produce `a a b a` instead of `a a a b`.
+// Only registered and selected consumers are scheduled, so messages of consumers which are not registered in this
+// process, such as one consumer only existing in one newer version during rolling deploys, are left for other processes.
+func buildRound(registry Registry, selected func(consumer Consumer) bool) []string {
+	var (
+		// sorted by name, since Consumers returns consumers sorted by name
+		names   []string
+		weights = make(map[string]int)
+		total   int
+	)
+	for _, consumer := range registry.Consumers() {
+		if !selected(consumer) {
+			continue
+		}
+		weight := 1
+		if wc, ok := consumer.(WeightedConsumer); ok && wc.Weight() > 1 {
+			weight = wc.Weight()
+		}
+		names = append(names, consumer.Name())
+		weights[consumer.Name()] = weight
+		total += weight
+	}
+
+	round := make([]string, 0, total)
+	current := make(map[string]int, len(names))
+	for i := 0; i < total; i++ {
+		var picked string
+		for _, name := range names {
+			current[name] += weights[name]
+			if picked == "" || current[name] > current[picked] {
+				picked = name
+			}
+		}
+		current[picked] -= total
+		round = append(round, picked)
+	}
+	return round
+}
@@ -0,0 +1,107 @@
+package mq
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildRound(t *testing.T) {
+	all := func(consumer Consumer) bool { return true }
+
+	tests := []struct {
+		name      string
+		consumers []testConsumer
+		selected  func(consumer Consumer) bool
+		want      []string
+	}{
+		{
+			name: "no consumer",
+			want: []string{},
+		},
+		{
+			name:      "equal weights take turns by name",
+			consumers: []testConsumer{{name: "c"}, {name: "a"}, {name: "b"}},
+			want:      []string{"a", "b", "c"},
+		},
+		{
+			name:      "weights less than 1 count as 1",
+			consumers: []testConsumer{{name: "a", weight: 0}, {name: "b", weight: -3}},
+			want:      []string{"a", "b"},
+		},
+		{
+			name:      "smooth weighted round-robin",
+			consumers: []testConsumer{{name: "a", weight: 3}, {name: "b", weight: 1}},
+			want:      []string{"a", "a", "b", "a"},
+		},
+		{
+			name:      "heavy weight is spread across the round",
+			consumers: []testConsumer{{name: "a", weight: 5}, {name: "b", weight: 1}, {name: "c", weight: 1}},
+			want:      []string{"a", "a", "b", "a", "c", "a", "a"},
+		},
+		{
+			name:      "unselected consumers are skipped",
+			consumers: []testConsumer{{name: "notify:a", weight: 2}, {name: "order:a"}, {name: "notify:b"}},
+			selected: func(consumer Consumer) bool {
+				return strings.HasPrefix(consumer.Name(), "notify:")
+			},
+			want: []string{"notify:a", "notify:b", "notify:a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			for _, consumer := range tt.consumers {
+				registry.Register(consumer)
+			}
+			selected := tt.selected
+			if selected == nil {
+				selected = all
+			}
+			if got := buildRound(registry, selected); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildRound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerNext(t *testing.T) {
+	registry := NewRegistry()
+	sched := newScheduler(registry, func(consumer Consumer) bool { return true })
+	if got := sched.next(); got != "" {
+		t.Fatalf("next() without consumers = %q, want empty", got)
+	}
+
+	registry.Register(testConsumer{name: "a", weight: 2})
+	registry.Register(testConsumer{name: "b"})
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, sched.next())
+	}
+	// consumers registered later are scheduled from the next round
+	want := []string{"a", "b", "a", "a", "b", "a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("next() = %v, want %v", got, want)
+	}
+	if size := sched.size(); size != 3 {
+		t.Errorf("size() = %d, want 3", size)
+	}
+}
+
+func TestSchedulerClaimNames(t *testing.T) {
+	registry := NewRegistry()
+	sched := newScheduler(registry, func(consumer Consumer) bool { return consumer.Name() != "c" })
+	if got := sched.claimNames(); len(got) != 0 {
+		t.Fatalf("claimNames() without consumers = %v, want none", got)
+	}
+
+	registry.Register(testConsumer{name: "b", aliases: []string{"b_old"}})
+	registry.Register(testConsumer{name: "a"})
+	registry.Register(testConsumer{name: "c"})
+	// names and aliases of selected consumers, sorted by consumer name
+	want := []string{"a", "b", "b_old"}
+	if got := sched.claimNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("claimNames() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Migrate creates tables used by this package, or upgrades tables created by earlier versions.
+// It's safe to run multiple times.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	if _, err := pool.Exec(ctx, Schema); err != nil {
+		return fmt.Errorf("error migrating mq schema: %w", err)
+	}
+	return nil
+}
+
+// Schema is the postgres schema used by this package. Run it with Migrate, or copy it into your own migrations.
+const Schema = `
+    CREATE TABLE IF NOT EXISTS queues (
+        id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+        consumer_name text NOT NULL,
+        message jsonb NOT NULL,
+        retry int DEFAULT 0 NOT NULL,
+        is_dead boolean DEFAULT false NOT NULL,
+        failed_reason text,
+        check_at timestamp NOT NULL,
+        ordering_key text,
+        schedule_key text,
+        coalesce_key text,
+        tracked boolean DEFAULT false NOT NULL,
+
+        created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+    );
+
+    -- upgrade table queues created by earlier versions
+    ALTER TABLE queues ADD COLUMN IF NOT EXISTS ordering_key text;
+    ALTER TABLE queues ADD COLUMN IF NOT EXISTS schedule_key text;
+    ALTER TABLE queues ADD COLUMN IF NOT EXISTS coalesce_key text;
+    ALTER TABLE queues ADD COLUMN IF NOT EXISTS tracked boolean DEFAULT false NOT NULL;
+
+    comment on column queues.consumer_name is 'which consumer should be used to consume this message';
+    comment on column queues.message is 'one json string containing message detail, like {order_id: 12}';
+    comment on column queues.retry is 'the number of times this message has been consumed';
+    comment on column queues.is_dead is 'when this message is dead, it means this message has reached max retry times, yet still failed to be consumed';
+    comment on column queues.failed_reason is 'log the failed message when consumer fails to consume this message';
+    comment on column queues.check_at is 'when cron system should check this message and consume it';
+    comment on column queues.ordering_key is 'messages with the same ordering key are consumed one by one in enqueue order, per consumer';
+    comment on column queues.schedule_key is 'caller supplied key to cancel or reschedule pending messages';
+    comment on column queues.coalesce_key is 'one pending message with the same coalescing key is replaced by new message, per consumer';
+    comment on column queues.tracked is 'whether this message has one status record at table queue_jobs';
+
+    CREATE INDEX IF NOT EXISTS queues_consumer_name_check_at_idx ON queues (consumer_name, check_at) WHERE is_dead = false;
+    CREATE INDEX IF NOT EXISTS queues_consumer_name_ordering_key_idx ON queues (consumer_name, ordering_key, id) WHERE ordering_key IS NOT NULL;
+    CREATE INDEX IF NOT EXISTS queues_schedule_key_idx ON queues (schedule_key) WHERE schedule_key IS NOT NULL;
+    CREATE UNIQUE INDEX IF NOT EXISTS queues_consumer_name_coalesce_key_idx ON queues (consumer_name, coalesce_key) WHERE is_dead = false;
+
+    CREATE TABLE IF NOT EXISTS queue_dedup_keys (
+        consumer_name text NOT NULL,
+        dedup_key text NOT NULL,
+        expires_at timestamp NOT NULL,
+
+        PRIMARY KEY (consumer_name, dedup_key)
+    );
+
+    comment on table queue_dedup_keys is 'idempotency keys of sent messages. Duplicate messages are skipped until the key expires';
+
+    CREATE TABLE IF NOT EXISTS queue_jobs (
+        id bigint PRIMARY KEY,
+        consumer_name text NOT NULL,
+        status text NOT NULL,
+        result jsonb,
+        error text,
+
+        created_at timestamp NOT NULL,
+        updated_at timestamp NOT NULL
+    );
+
+    -- status check is only (re)created when missing, or when it was created by earlier versions without newer
+    -- statuses, since replacing it locks the whole table
+    DO $$
+    BEGIN
+        IF NOT EXISTS (
+            SELECT 1 FROM pg_constraint
+            WHERE conrelid = 'queue_jobs'::regclass AND conname = 'queue_jobs_status_check'
+                AND pg_get_constraintdef(oid) LIKE '%canceled%'
+        ) THEN
+            ALTER TABLE queue_jobs DROP CONSTRAINT IF EXISTS queue_jobs_status_check;
+            ALTER TABLE queue_jobs ADD CONSTRAINT queue_jobs_status_check
+                check (status in ('pending', 'running', 'succeeded', 'failed', 'dead', 'canceled'));
+        END IF;
+    END $$;
+
+    comment on table queue_jobs is 'status records of tracked messages. id is the queue id';
+    comment on column queue_jobs.result is 'consumer result saved as json';
+    comment on column queue_jobs.error is 'last error when consume failed';
+
+    CREATE TABLE IF NOT EXISTS queue_archives (
+        id bigint PRIMARY KEY,
+        consumer_name text NOT NULL,
+        event text NOT NULL,
+        message jsonb NOT NULL,
+        attempts int NOT NULL,
+        duration_ms bigint NOT NULL,
+
+        created_at timestamp NOT NULL,
+        completed_at timestamp NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS queue_archives_completed_at_idx ON queue_archives (completed_at);
+    CREATE INDEX IF NOT EXISTS queue_archives_event_created_at_idx ON queue_archives (event, created_at, id);
+
+    comment on table queue_archives is 'successfully consumed messages, when consume runs with archive mode. id is the queue id';
+    comment on column queue_archives.attempts is 'the number of times this message has been consumed, including the successful one';
+    comment on column queue_archives.duration_ms is 'how long the successful consume took, in milliseconds';
+
+    CREATE TABLE IF NOT EXISTS queue_attempts (
+        id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+        queue_id bigint NOT NULL,
+        attempt int NOT NULL,
+        started_at timestamp NOT NULL,
+        finished_at timestamp NOT NULL,
+        worker_id text NOT NULL,
+        error text,
+        panicked boolean DEFAULT false NOT NULL
+    );
+
+    CREATE INDEX IF NOT EXISTS queue_attempts_queue_id_idx ON queue_attempts (queue_id);
+    CREATE INDEX IF NOT EXISTS queue_attempts_finished_at_idx ON queue_attempts (finished_at);
+
+    comment on table queue_attempts is 'consume attempts of messages, when consume runs with attempt history';
+    comment on column queue_attempts.worker_id is 'which worker consumed this message, in format {hostname}:{pid}:{worker_index}';
+    comment on column queue_attempts.error is 'error of this attempt. null means this attempt succeeded';
+
+    CREATE TABLE IF NOT EXISTS queue_dead_letters (
+        id bigint PRIMARY KEY,
+        consumer_name text NOT NULL,
+        message jsonb NOT NULL,
+        retry int NOT NULL,
+        failed_reason text,
+        check_at timestamp NOT NULL,
+        ordering_key text,
+        schedule_key text,
+        coalesce_key text,
+        tracked boolean DEFAULT false NOT NULL,
+
+        created_at timestamp NOT NULL,
+        dead_at timestamp NOT NULL
+    );
+
+    comment on table queue_dead_letters is 'dead messages with full context, when consume runs with dead letter table. id is the queue id';
+`
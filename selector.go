@@ -0,0 +1,102 @@
+package mq
+
+import "strings"
+
+// TaggedConsumer is one optional interface a Consumer may implement to declare tags, such as `heavy`,
+// so consume processes may select consumers by tag. See WithInclude and WithExclude.
+type TaggedConsumer interface {
+	Tags() []string
+}
+
+// Selector selects consumers. One consumer is selected when it matches every non-empty field, and it matches one
+// field when it matches any value of that field, i.e, Selector{Prefixes: []string{"notify:"}, Tags: []string{"heavy"}}
+// selects heavy consumers of service notify. Empty selector selects all consumers.
+type Selector struct {
+	// consumer names
+	Names []string
+	// consumer name prefixes, such as `notify:`
+	Prefixes []string
+	// events consumers subscribe to
+	Events []Event
+	// tags declared by TaggedConsumer
+	Tags []string
+}
+
+func (s Selector) matches(consumer Consumer) bool {
+	if len(s.Names) > 0 && !containsString(s.Names, consumer.Name()) {
+		return false
+	}
+	if len(s.Prefixes) > 0 {
+		matched := false
+		for _, prefix := range s.Prefixes {
+			if strings.HasPrefix(consumer.Name(), prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.Events) > 0 {
+		matched := false
+		for _, event := range s.Events {
+			if event == consumer.Event() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.Tags) > 0 {
+		tc, ok := consumer.(TaggedConsumer)
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, tag := range tc.Tags() {
+			if containsString(s.Tags, tag) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// selected returns whether this consume process runs the consumer, i.e, it matches one include selector if any,
+// and matches no exclude selector.
+func (c consume) selected(consumer Consumer) bool {
+	if len(c.include) > 0 {
+		matched := false
+		for _, s := range c.include {
+			if s.matches(consumer) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, s := range c.exclude {
+		if s.matches(consumer) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
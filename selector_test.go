@@ -0,0 +1,87 @@
+package mq
+
+import "testing"
+
+func TestSelectorMatches(t *testing.T) {
+	heavy := testConsumer{name: "notify:export:order_created", event: "order_created", tags: []string{"heavy", "io"}}
+	light := testConsumer{name: "order:order:order_paid", event: "order_paid"}
+
+	tests := []struct {
+		name     string
+		selector Selector
+		consumer testConsumer
+		want     bool
+	}{
+		{"empty selector matches all", Selector{}, light, true},
+		{"name", Selector{Names: []string{"order:order:order_paid"}}, light, true},
+		{"any name", Selector{Names: []string{"a", "order:order:order_paid"}}, light, true},
+		{"other name", Selector{Names: []string{"order:order"}}, light, false},
+		{"prefix", Selector{Prefixes: []string{"notify:"}}, heavy, true},
+		{"any prefix", Selector{Prefixes: []string{"order:", "notify:"}}, heavy, true},
+		{"other prefix", Selector{Prefixes: []string{"order:"}}, heavy, false},
+		{"event", Selector{Events: []Event{"order_created"}}, heavy, true},
+		{"other event", Selector{Events: []Event{"order_created"}}, light, false},
+		{"any tag", Selector{Tags: []string{"cpu", "io"}}, heavy, true},
+		{"other tag", Selector{Tags: []string{"cpu"}}, heavy, false},
+		{"no tags", Selector{Tags: []string{"heavy"}}, light, false},
+		{"all fields match", Selector{Prefixes: []string{"notify:"}, Events: []Event{"order_created"}, Tags: []string{"heavy"}}, heavy, true},
+		{"one field doesn't match", Selector{Prefixes: []string{"notify:"}, Tags: []string{"cpu"}}, heavy, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selector.matches(tt.consumer); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsumeSelected(t *testing.T) {
+	notify := testConsumer{name: "notify:order:order_created", event: "order_created"}
+	export := testConsumer{name: "notify:export:order_created", event: "order_created", tags: []string{"heavy"}}
+	order := testConsumer{name: "order:order:order_paid", event: "order_paid"}
+
+	tests := []struct {
+		name string
+		opts []ConsumeOption
+		want map[string]bool
+	}{
+		{
+			name: "all consumers by default",
+			want: map[string]bool{notify.name: true, export.name: true, order.name: true},
+		},
+		{
+			name: "any include selector",
+			opts: []ConsumeOption{
+				WithInclude(Selector{Names: []string{order.name}}),
+				WithInclude(Selector{Tags: []string{"heavy"}}),
+			},
+			want: map[string]bool{notify.name: false, export.name: true, order.name: true},
+		},
+		{
+			name: "exclude wins over include",
+			opts: []ConsumeOption{
+				WithInclude(Selector{Prefixes: []string{"notify:"}}),
+				WithExclude(Selector{Tags: []string{"heavy"}}),
+			},
+			want: map[string]bool{notify.name: true, export.name: false, order.name: false},
+		},
+		{
+			name: "exclude only",
+			opts: []ConsumeOption{WithExclude(Selector{Events: []Event{"order_paid"}})},
+			want: map[string]bool{notify.name: true, export.name: true, order.name: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NeWConsume(nil, nil, tt.opts...).(consume)
+			for _, consumer := range []testConsumer{notify, export, order} {
+				if got := c.selected(consumer); got != tt.want[consumer.name] {
+					t.Errorf("selected(%s) = %v, want %v", consumer.name, got, tt.want[consumer.name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// QueueStats holds message statistics of one consumer or one event.
+type QueueStats struct {
+	// live messages due to be consumed now
+	Due int64 `json:"due"`
+	// live messages to be consumed in future, i.e, delayed messages or messages waiting for retry
+	Scheduled int64 `json:"scheduled"`
+	// live messages which have failed at least once
+	InRetry int64 `json:"in_retry"`
+	// dead messages, including messages in table `queue_dead_letters`
+	Dead int64 `json:"dead"`
+	// how long the oldest due message has been waiting since its check time. Zero when no message is due.
+	OldestDueAge time.Duration `json:"oldest_due_age"`
+	// average retry times of live messages
+	AvgRetry float64 `json:"avg_retry"`
+}
+
+// Stats holds message statistics per consumer and per event.
+type Stats struct {
+	Consumers map[string]QueueStats `json:"consumers"`
+	Events    map[Event]QueueStats  `json:"events"`
+}
+
+func (a admin) Stats(ctx context.Context) (*Stats, error) {
+	now := time.Now().UTC()
+	// one scan of both tables, grouped by consumer and by event at the same time
+	query := `with q as (
+			select consumer_name, message->>'event' as event, retry, is_dead, check_at from queues
+			union all
+			select consumer_name, message->>'event', retry, true, check_at from queue_dead_letters
+		)
+		select grouping(consumer_name) = 0 as by_consumer, consumer_name, event,
+			count(*) filter (where not is_dead and check_at <= $1),
+			count(*) filter (where not is_dead and check_at > $1),
+			count(*) filter (where not is_dead and retry > 0),
+			count(*) filter (where is_dead),
+			min(check_at) filter (where not is_dead and check_at <= $1),
+			coalesce(avg(retry) filter (where not is_dead), 0)::float8
+		from q group by grouping sets ((consumer_name), (event))`
+
+	rows, err := a.pool.Query(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &Stats{
+		Consumers: make(map[string]QueueStats),
+		Events:    make(map[Event]QueueStats),
+	}
+	for rows.Next() {
+		var (
+			byConsumer   bool
+			consumerName *string
+			event        *string
+			oldestDueAt  *time.Time
+			s            QueueStats
+		)
+		if err := rows.Scan(&byConsumer, &consumerName, &event, &s.Due, &s.Scheduled, &s.InRetry, &s.Dead,
+			&oldestDueAt, &s.AvgRetry); err != nil {
+			return nil, fmt.Errorf("error scanning stats: %w", err)
+		}
+		if oldestDueAt != nil {
+			s.OldestDueAge = now.Sub(*oldestDueAt)
+		}
+		if byConsumer && consumerName != nil {
+			stats.Consumers[*consumerName] = s
+		} else if !byConsumer && event != nil {
+			stats.Events[Event(*event)] = s
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error selecting stats: %w", err)
+	}
+	return stats, nil
+}